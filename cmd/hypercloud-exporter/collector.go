@@ -0,0 +1,195 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Where the inventory comes from, the hypercloud client in practice
+type inventorySource interface {
+	Inventory() (hypercloud.Inventory, []error)
+}
+
+// Polls the API on an interval and serves the last good inventory. Scrapes only
+// ever read the snapshot, so however often Prometheus scrapes, the API sees one
+// round of list calls per interval. Each inventory is turned into a complete new
+// snapshot which replaces the old one in one go, a scrape never sees half of one.
+type collector struct {
+	source inventorySource
+	logger *slog.Logger
+
+	// Called with every successful inventory, after the metrics are updated
+	onInventory func(hypercloud.Inventory)
+
+	mu       sync.Mutex //one inventory at a time
+	snapshot atomic.Pointer[[]prometheus.Metric]
+
+	up             prometheus.Gauge
+	scrapeDuration prometheus.Gauge
+	lastSuccess    prometheus.Gauge
+	scrapes        prometheus.Counter
+	scrapeErrors   prometheus.Counter
+}
+
+func desc(name string, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName("hypercloud", "", name), help, labels, nil)
+}
+
+var (
+	instancesDesc        = desc("instances", "Instances by state, region and performance tier.", "state", "region", "tier")
+	instanceMemoryDesc   = desc("instance_memory_megabytes", "Memory allocated to instances by region and performance tier.", "region", "tier")
+	diskGBDesc           = desc("disk_size_gigabytes", "Total size of disks by region and performance tier.", "region", "tier")
+	disksDesc            = desc("disks", "Disks by state, region and performance tier.", "state", "region", "tier")
+	unattachedDisksDesc  = desc("disks_unattached", "Disks not attached to any instance, by region.", "region")
+	ipsDesc              = desc("ip_addresses", "IP addresses by region and type.", "region", "type")
+	unusedIPsDesc        = desc("ip_addresses_unused", "IP addresses not attached to any instance, by region and type.", "region", "type")
+	networksDesc         = desc("networks", "Networks by region.", "region")
+	performanceTiersDesc = desc("performance_tiers", "Performance tiers on offer by kind and region.", "kind", "region")
+
+	inventoryDescs = []*prometheus.Desc{instancesDesc, instanceMemoryDesc, diskGBDesc, disksDesc, unattachedDisksDesc, ipsDesc, unusedIPsDesc, networksDesc, performanceTiersDesc}
+)
+
+func newCollector(source inventorySource, logger *slog.Logger, reg prometheus.Registerer) *collector {
+	c := &collector{
+		source: source,
+		logger: logger,
+
+		up: prometheus.NewGauge(prometheus.GaugeOpts{Namespace: "hypercloud", Name: "up",
+			Help: "Whether the last inventory of the API succeeded."}),
+		scrapeDuration: prometheus.NewGauge(prometheus.GaugeOpts{Namespace: "hypercloud", Name: "scrape_duration_seconds",
+			Help: "How long the last inventory of the API took."}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{Namespace: "hypercloud", Name: "last_successful_scrape_timestamp_seconds",
+			Help: "When the inventory metrics were last updated."}),
+		scrapes: prometheus.NewCounter(prometheus.CounterOpts{Namespace: "hypercloud", Name: "scrapes_total",
+			Help: "Inventories of the API attempted."}),
+		scrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{Namespace: "hypercloud", Name: "scrape_errors_total",
+			Help: "Inventories of the API which failed."}),
+	}
+	reg.MustRegister(c, c.up, c.scrapeDuration, c.lastSuccess, c.scrapes, c.scrapeErrors)
+	return c
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range inventoryDescs {
+		ch <- d
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	if snapshot := c.snapshot.Load(); snapshot != nil {
+		for _, m := range *snapshot {
+			ch <- m
+		}
+	}
+}
+
+// Updates the metrics every interval until stop is closed
+func (c *collector) Run(interval time.Duration, stop <-chan struct{}) {
+	for {
+		c.Scrape()
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Takes one inventory. On failure the inventory gauges keep their previous
+// values (hypercloud_up says they are stale) rather than dropping to zero.
+func (c *collector) Scrape() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start := time.Now()
+	inv, err := c.source.Inventory()
+	c.scrapeDuration.Set(time.Since(start).Seconds())
+	c.scrapes.Inc()
+	if err != nil {
+		c.scrapeErrors.Inc()
+		c.up.Set(0)
+		msgs := make([]string, len(err))
+		for i, e := range err {
+			msgs[i] = e.Error()
+		}
+		c.logger.Warn("inventory failed", "error", strings.Join(msgs, "; "))
+		return
+	}
+	c.update(inv)
+	c.up.Set(1)
+	c.lastSuccess.SetToCurrentTime()
+	if c.onInventory != nil {
+		c.onInventory(inv)
+	}
+}
+
+func (c *collector) update(inv hypercloud.Inventory) {
+	type series struct {
+		desc   *prometheus.Desc
+		labels []string
+		value  float64
+	}
+	byKey := make(map[string]*series)
+	var order []*series
+	add := func(d *prometheus.Desc, v float64, labels ...string) {
+		k := d.String() + "\xff" + strings.Join(labels, "\xff")
+		if byKey[k] == nil {
+			byKey[k] = &series{desc: d, labels: labels}
+			order = append(order, byKey[k])
+		}
+		byKey[k].value += v
+	}
+	for _, i := range inv.Instances {
+		region, tier := label(i.RegionCode, i.Region), label(i.TierName, i.Tier)
+		add(instancesDesc, 1, i.State, region, tier)
+		add(instanceMemoryDesc, i.MemoryMB, region, tier)
+	}
+	for _, d := range inv.Disks {
+		region, tier := label(d.RegionCode, d.Region), label(d.TierName, d.Tier)
+		add(disksDesc, 1, d.State, region, tier)
+		add(diskGBDesc, d.SizeGB, region, tier)
+		// Zero series for regions with none, so they don't read as missing data
+		unattached := 0.0
+		if d.State == hypercloud.DiskStateUnattached {
+			unattached = 1
+		}
+		add(unattachedDisksDesc, unattached, region)
+	}
+	for _, ip := range inv.IPs {
+		region := label(ip.RegionCode, ip.Region)
+		add(ipsDesc, 1, region, ip.Type)
+		unused := 0.0
+		if ip.Instance == "" {
+			unused = 1
+		}
+		add(unusedIPsDesc, unused, region, ip.Type)
+	}
+	for _, n := range inv.Networks {
+		add(networksDesc, 1, label(n.RegionCode, n.Region))
+	}
+	for _, t := range inv.InstanceTiers {
+		add(performanceTiersDesc, 1, "instance", label(t.RegionCode, t.Region))
+	}
+	for _, t := range inv.DiskTiers {
+		add(performanceTiersDesc, 1, "disk", label(t.RegionCode, t.Region))
+	}
+
+	snapshot := make([]prometheus.Metric, 0, len(order))
+	for _, m := range order {
+		snapshot = append(snapshot, prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, m.value, m.labels...))
+	}
+	c.snapshot.Store(&snapshot)
+}
+
+// The human readable name if there is one, otherwise the id
+func label(name string, id string) string {
+	if name != "" {
+		return name
+	}
+	return id
+}
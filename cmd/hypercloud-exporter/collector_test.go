@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeSource struct {
+	inv hypercloud.Inventory
+	err []error
+}
+
+func (f *fakeSource) Inventory() (hypercloud.Inventory, []error) {
+	return f.inv, f.err
+}
+
+// The value of the series with the label values (in the order they were declared), -1 if there is none
+func gathered(t *testing.T, reg *prometheus.Registry, name string, labels ...string) float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			pairs := m.GetLabel()
+			if len(pairs) != len(labels) {
+				continue
+			}
+			// Gather sorts labels by name, match on the set of values
+			for _, v := range labels {
+				found := false
+				for _, p := range pairs {
+					if p.GetValue() == v {
+						found = true
+					}
+				}
+				if !found {
+					continue metrics
+				}
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	return -1
+}
+
+func TestCollectorScrape(t *testing.T) {
+	source := &fakeSource{inv: hypercloud.Inventory{
+		Instances: []hypercloud.InventoryInstance{
+			{Id: "i-1", State: "running", RegionCode: "SY3", TierName: "standard", MemoryMB: 2048},
+			{Id: "i-2", State: "running", RegionCode: "SY3", TierName: "standard", MemoryMB: 1024},
+			{Id: "i-3", State: "stopped", RegionCode: "SY3", Tier: "tier-x"},
+		},
+		Disks: []hypercloud.InventoryDisk{
+			{Id: "d-1", State: hypercloud.DiskStateAttached, RegionCode: "SY3", TierName: "ssd", SizeGB: 20},
+			{Id: "d-2", State: hypercloud.DiskStateUnattached, RegionCode: "SY3", TierName: "ssd", SizeGB: 50},
+		},
+		IPs: []hypercloud.InventoryIP{
+			{Id: "ip-1", Type: "public", RegionCode: "SY3", Instance: "i-1"},
+			{Id: "ip-2", Type: "public", RegionCode: "SY3"},
+		},
+	}}
+	reg := prometheus.NewRegistry()
+	c := newCollector(source, slog.New(slog.NewTextHandler(io.Discard, nil)), reg)
+	c.Scrape()
+
+	checks := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"running standard instances", gathered(t, reg, "hypercloud_instances", "running", "SY3", "standard"), 2},
+		{"instance tier falls back to id", gathered(t, reg, "hypercloud_instances", "stopped", "SY3", "tier-x"), 1},
+		{"memory", gathered(t, reg, "hypercloud_instance_memory_megabytes", "SY3", "standard"), 3072},
+		{"disk GB", gathered(t, reg, "hypercloud_disk_size_gigabytes", "SY3", "ssd"), 70},
+		{"unattached disks", gathered(t, reg, "hypercloud_disks_unattached", "SY3"), 1},
+		{"unused IPs", gathered(t, reg, "hypercloud_ip_addresses_unused", "SY3", "public"), 1},
+		{"up", testutil.ToFloat64(c.up), 1},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s: expected %g, got %g", check.name, check.want, check.got)
+		}
+	}
+
+	// A failed inventory keeps the last good figures and says so
+	source.err = []error{fmt.Errorf("API Error: 502")}
+	source.inv = hypercloud.Inventory{}
+	c.Scrape()
+	if up := testutil.ToFloat64(c.up); up != 0 {
+		t.Errorf("expected up to be 0 after a failure, got %g", up)
+	}
+	if n := gathered(t, reg, "hypercloud_instances", "running", "SY3", "standard"); n != 2 {
+		t.Errorf("expected the previous inventory to be kept, got %g instances", n)
+	}
+	if n := testutil.ToFloat64(c.scrapeErrors); n != 1 {
+		t.Errorf("expected 1 scrape error, got %g", n)
+	}
+}
+
+func TestCollectorSnapshotIsAtomic(t *testing.T) {
+	source := &fakeSource{}
+	reg := prometheus.NewRegistry()
+	c := newCollector(source, slog.New(slog.NewTextHandler(io.Discard, nil)), reg)
+	inventories := []hypercloud.Inventory{
+		{Instances: []hypercloud.InventoryInstance{{State: "running", RegionCode: "SY3"}, {State: "running", RegionCode: "SY3"}}},
+		{Instances: []hypercloud.InventoryInstance{{State: "running", RegionCode: "ME1"}, {State: "running", RegionCode: "ME1"}}},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			source.inv = inventories[i%2]
+			c.Scrape()
+		}
+	}()
+	c.update(inventories[0])
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		total := 0.0
+		families, _ := reg.Gather()
+		for _, f := range families {
+			if f.GetName() == "hypercloud_instances" {
+				for _, m := range f.GetMetric() {
+					total += m.GetGauge().GetValue()
+				}
+			}
+		}
+		if total != 2 {
+			t.Fatalf("A scrape saw a partial inventory of %g instances", total)
+		}
+	}
+}
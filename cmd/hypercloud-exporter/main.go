@@ -0,0 +1,65 @@
+// Command hypercloud-exporter exposes a HyperCloud account's inventory as
+// Prometheus metrics: instances by state, region and tier, disk capacity,
+// unattached disks, unused IP addresses and the health of the API polling.
+// Running instances are also listed for Prometheus service discovery on /sd
+// (http_sd_configs) and, with -file-sd, in a file for file_sd_configs.
+//
+//	HYPERCLOUD_TOKEN=... hypercloud-exporter -url https://api.example.com -listen :9617
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	url := flag.String("url", os.Getenv("HYPERCLOUD_URL"), "HyperCloud API base URL (or $HYPERCLOUD_URL)")
+	token := flag.String("token", os.Getenv("HYPERCLOUD_TOKEN"), "API token (or $HYPERCLOUD_TOKEN)")
+	listen := flag.String("listen", ":9617", "Address to serve metrics on")
+	interval := flag.Duration("interval", time.Minute, "How often to take an inventory of the account")
+	sdPort := flag.Int("sd-port", 9100, "Port of the targets listed for service discovery")
+	sdPublic := flag.Bool("sd-public", false, "List targets by public IP rather than private")
+	fileSD := flag.String("file-sd", "", "Also write the service discovery targets to this file, for file_sd_configs")
+	debug := flag.Bool("debug", false, "Log every API request")
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if *url == "" || *token == "" {
+		logger.Error("an API URL and token are required")
+		os.Exit(2)
+	}
+
+	hc, _ := hypercloud.NewHypercloud(*url, *token)
+	hc.SetLogger(logger)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	c := newCollector(&hc, logger, reg)
+	sd := &discovery{port: *sdPort, preferPublic: *sdPublic, filePath: *fileSD}
+	c.onInventory = func(inv hypercloud.Inventory) {
+		if err := sd.update(inv); err != nil {
+			logger.Warn("writing file_sd targets failed", "path", *fileSD, "error", err)
+		}
+	}
+	go c.Run(*interval, nil)
+
+	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	http.Handle("/sd", sd)
+	logger.Info("serving metrics", "listen", *listen, "interval", *interval)
+	if err := http.ListenAndServe(*listen, nil); err != nil {
+		logger.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+)
+
+// Prometheus service discovery from the same inventory the metrics come from:
+// served over HTTP for http_sd_configs and optionally written to a file for
+// file_sd_configs. Each running instance is a target group of its own with
+// __meta_hypercloud_* labels, e.g. to keep the region as a label:
+//
+//	relabel_configs:
+//	  - source_labels: [__meta_hypercloud_region]
+//	    target_label: region
+
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+type discovery struct {
+	port         int
+	preferPublic bool
+	filePath     string //empty to not write a file
+
+	mu     sync.RWMutex
+	groups []targetGroup //nil until the first inventory
+}
+
+func (d *discovery) update(inv hypercloud.Inventory) error {
+	groups := targetGroups(inv, d.port, d.preferPublic)
+	d.mu.Lock()
+	d.groups = groups
+	d.mu.Unlock()
+	if d.filePath == "" {
+		return nil
+	}
+	return writeFileSD(d.filePath, groups)
+}
+
+// Serves the target groups, or a 503 until there has been an inventory so
+// Prometheus doesn't take an empty list to mean every target has gone
+func (d *discovery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	groups := d.groups
+	d.mu.RUnlock()
+	if groups == nil {
+		http.Error(w, "no inventory yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+func targetGroups(inv hypercloud.Inventory, port int, preferPublic bool) []targetGroup {
+	groups := []targetGroup{}
+	for _, i := range inv.Instances {
+		if i.State != hypercloud.InstanceStateRunning {
+			continue
+		}
+		address := firstOf(i.PrivateIPs, i.PublicIPs)
+		if preferPublic {
+			address = firstOf(i.PublicIPs, i.PrivateIPs)
+		}
+		if address == "" {
+			continue
+		}
+		labels := map[string]string{
+			"__meta_hypercloud_instance_id":      i.Id,
+			"__meta_hypercloud_instance_name":    i.Name,
+			"__meta_hypercloud_region":           label(i.RegionCode, i.Region),
+			"__meta_hypercloud_performance_tier": label(i.TierName, i.Tier),
+			"__meta_hypercloud_public_ip":        firstOf(i.PublicIPs),
+			"__meta_hypercloud_private_ip":       firstOf(i.PrivateIPs),
+		}
+		for k, v := range contextLabels(i.Context) {
+			labels["__meta_hypercloud_context_"+k] = v
+		}
+		groups = append(groups, targetGroup{
+			Targets: []string{net.JoinHostPort(address, strconv.Itoa(port))},
+			Labels:  labels,
+		})
+	}
+	return groups
+}
+
+func firstOf(lists ...[]string) string {
+	for _, l := range lists {
+		if len(l) > 0 {
+			return l[0]
+		}
+	}
+	return ""
+}
+
+// The context as labels. Keys which come out as the same label name (say
+// "team.name" and "team_name") can't all be kept: a key that is a valid name
+// as it is wins, otherwise the first in sorted order, and the rest are dropped.
+func contextLabels(context map[string]string) map[string]string {
+	keys := make([]string, 0, len(context))
+	for k := range context {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if va, vb := labelName(keys[a]) == keys[a], labelName(keys[b]) == keys[b]; va != vb {
+			return va
+		}
+		return keys[a] < keys[b]
+	})
+	labels := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if _, taken := labels[labelName(k)]; !taken {
+			labels[labelName(k)] = context[k]
+		}
+	}
+	return labels
+}
+
+// Context keys made into valid label names: anything but letters, digits and
+// underscores becomes an underscore
+func labelName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// Written to a temporary file and renamed, Prometheus watches the file and
+// must never see it half written
+func writeFileSD(path string, groups []targetGroup) error {
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+)
+
+func TestDiscovery(t *testing.T) {
+	inv := hypercloud.Inventory{Instances: []hypercloud.InventoryInstance{
+		{Id: "i-1", Name: "web", State: "running", RegionCode: "SY3", TierName: "standard",
+			PublicIPs: []string{"203.0.113.5"}, PrivateIPs: []string{"10.0.0.5"},
+			Context: map[string]string{"role": "web", "team.name": "ops"}},
+		{Id: "i-2", Name: "old", State: "stopped", PrivateIPs: []string{"10.0.0.6"}},
+		{Id: "i-3", Name: "no-ip", State: "running"},
+	}}
+	path := filepath.Join(t.TempDir(), "hypercloud.json")
+	d := &discovery{port: 9100, filePath: path}
+
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest("GET", "/sd", nil))
+	if rec.Code != 503 {
+		t.Errorf("expected 503 before the first inventory, got %d", rec.Code)
+	}
+
+	if err := d.update(inv); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest("GET", "/sd", nil))
+	var served []targetGroup
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 1 || served[0].Targets[0] != "10.0.0.5:9100" {
+		t.Fatalf("expected only the running instance with an address, by private IP, got %+v", served)
+	}
+	labels := served[0].Labels
+	if labels["__meta_hypercloud_region"] != "SY3" || labels["__meta_hypercloud_context_team_name"] != "ops" || labels["__meta_hypercloud_public_ip"] != "203.0.113.5" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+
+	var written []targetGroup
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &written)
+	}
+	if err != nil || len(written) != 1 || written[0].Labels["__meta_hypercloud_instance_name"] != "web" {
+		t.Errorf("unexpected file_sd output %s (%v)", data, err)
+	}
+
+	if groups := targetGroups(inv, 9100, true); groups[0].Targets[0] != "203.0.113.5:9100" {
+		t.Errorf("expected the public IP to be preferred, got %v", groups[0].Targets)
+	}
+}
+
+func TestContextLabelCollisions(t *testing.T) {
+	tests := []struct {
+		context map[string]string
+		want    map[string]string
+	}{
+		{map[string]string{"team.name": "a", "team_name": "b"}, map[string]string{"team_name": "b"}},
+		{map[string]string{"team.name": "a", "team-name": "b"}, map[string]string{"team_name": "b"}},
+		{map[string]string{"team.name": "a", "role": "web"}, map[string]string{"team_name": "a", "role": "web"}},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ { //map order must not matter
+			if got := contextLabels(tt.context); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%v: expected %v, got %v", tt.context, tt.want, got)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+)
+
+// Ansible's dynamic inventory format. Hostvars are included under _meta so
+// Ansible doesn't have to run the script again with --host for every host.
+//
+// Hosts are named after their instance (the id when the name is taken by
+// another instance) and grouped by region_<code>, state_<state>, tier_<name>
+// and tag_<key>_<value> (tag_<key> when the value is empty) for every instance
+// context key.
+
+type group struct {
+	Hosts []string `json:"hosts"`
+}
+
+// Which address ansible_host is set to: the first of these kinds the instance has
+type addressPreference []string
+
+func parsePreference(s string) addressPreference {
+	pref := addressPreference{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p == "public" || p == "private" {
+			pref = append(pref, p)
+		}
+	}
+	if len(pref) == 0 {
+		pref = addressPreference{"public", "private"}
+	}
+	return pref
+}
+
+func (p addressPreference) address(i hypercloud.InventoryInstance) string {
+	for _, kind := range p {
+		ips := i.PublicIPs
+		if kind == "private" {
+			ips = i.PrivateIPs
+		}
+		if len(ips) > 0 {
+			return ips[0]
+		}
+	}
+	return ""
+}
+
+// Host names for the instances, in the same order. An instance is named after
+// its id when it has no name or shares it with another, so a host's name
+// doesn't depend on the order the API lists instances in.
+func hostNames(instances []hypercloud.InventoryInstance) []string {
+	count := make(map[string]int)
+	for _, i := range instances {
+		count[i.Name]++
+	}
+	names := make([]string, len(instances))
+	for n, i := range instances {
+		names[n] = i.Name
+		if i.Name == "" || count[i.Name] > 1 {
+			names[n] = i.Id
+		}
+	}
+	return names
+}
+
+func hostVars(i hypercloud.InventoryInstance, pref addressPreference) map[string]interface{} {
+	vars := map[string]interface{}{
+		"hypercloud_id":          i.Id,
+		"hypercloud_name":        i.Name,
+		"hypercloud_state":       i.State,
+		"hypercloud_region":      label(i.RegionCode, i.Region),
+		"hypercloud_tier":        label(i.TierName, i.Tier),
+		"hypercloud_public_ips":  nonNil(i.PublicIPs),
+		"hypercloud_private_ips": nonNil(i.PrivateIPs),
+		"hypercloud_context":     i.Context,
+	}
+	if address := pref.address(i); address != "" {
+		vars["ansible_host"] = address
+	}
+	return vars
+}
+
+// The full --list output
+func listInventory(inv hypercloud.Inventory, pref addressPreference) map[string]interface{} {
+	groups := make(map[string]*group)
+	add := func(prefix string, value string, host string) {
+		if value == "" {
+			return
+		}
+		name := groupName(prefix + value)
+		if groups[name] == nil {
+			groups[name] = &group{}
+		}
+		groups[name].Hosts = append(groups[name].Hosts, host)
+	}
+
+	hostvars := make(map[string]interface{})
+	names := hostNames(inv.Instances)
+	for n, i := range inv.Instances {
+		host := names[n]
+		hostvars[host] = hostVars(i, pref)
+		add("region_", label(i.RegionCode, i.Region), host)
+		add("state_", i.State, host)
+		add("tier_", label(i.TierName, i.Tier), host)
+		for k, v := range i.Context {
+			if v == "" {
+				add("tag_", k, host)
+			} else {
+				add("tag_", k+"_"+v, host)
+			}
+		}
+	}
+
+	out := map[string]interface{}{"_meta": map[string]interface{}{"hostvars": hostvars}}
+	children := make([]string, 0, len(groups))
+	for name, g := range groups {
+		sort.Strings(g.Hosts)
+		out[name] = g
+		children = append(children, name)
+	}
+	sort.Strings(children)
+	out["all"] = map[string]interface{}{"hosts": nonNil(names), "children": children}
+	return out
+}
+
+// The --host output, empty if there is no such host
+func hostInventory(inv hypercloud.Inventory, pref addressPreference, host string) map[string]interface{} {
+	names := hostNames(inv.Instances)
+	for n, i := range inv.Instances {
+		if names[n] == host {
+			return hostVars(i, pref)
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// Group names Ansible accepts without complaint: lower case letters, digits and underscores
+func groupName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(s))
+}
+
+func label(name string, id string) string {
+	if name != "" {
+		return name
+	}
+	return id
+}
+
+// So empty lists come out as [] rather than null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+)
+
+func TestListInventory(t *testing.T) {
+	inv := hypercloud.Inventory{Instances: []hypercloud.InventoryInstance{
+		{Id: "i-1", Name: "web", State: "running", RegionCode: "SY3", TierName: "Standard",
+			PublicIPs: []string{"203.0.113.5"}, PrivateIPs: []string{"10.0.0.5"},
+			Context: map[string]string{"role": "web-front"}},
+		{Id: "i-2", Name: "web", State: "stopped", RegionCode: "SY3", PrivateIPs: []string{"10.0.0.6"}},
+		{Id: "i-3", Name: "db", State: "running", RegionCode: "SY3"},
+	}}
+	out := listInventory(inv, parsePreference(""))
+
+	// Through JSON, as Ansible sees it
+	data, _ := json.Marshal(out)
+	var parsed map[string]interface{}
+	json.Unmarshal(data, &parsed)
+	hosts := func(group string) []string {
+		var names []string
+		for _, h := range parsed[group].(map[string]interface{})["hosts"].([]interface{}) {
+			names = append(names, h.(string))
+		}
+		return names
+	}
+	if got := hosts("region_sy3"); !reflect.DeepEqual(got, []string{"db", "i-1", "i-2"}) {
+		t.Errorf("expected all hosts in region_sy3, both duplicate names by id, got %v", got)
+	}
+	if got := hosts("state_running"); !reflect.DeepEqual(got, []string{"db", "i-1"}) {
+		t.Errorf("unexpected state_running %v", got)
+	}
+	if got := hosts("tier_standard"); !reflect.DeepEqual(got, []string{"i-1"}) {
+		t.Errorf("unexpected tier_standard %v", got)
+	}
+	if got := hosts("tag_role_web_front"); !reflect.DeepEqual(got, []string{"i-1"}) {
+		t.Errorf("unexpected tag_role_web_front %v", got)
+	}
+
+	hostvars := parsed["_meta"].(map[string]interface{})["hostvars"].(map[string]interface{})
+	if h := hostvars["i-1"].(map[string]interface{})["ansible_host"]; h != "203.0.113.5" {
+		t.Errorf("expected the public IP by default, got %v", h)
+	}
+	if h := hostvars["i-2"].(map[string]interface{})["ansible_host"]; h != "10.0.0.6" {
+		t.Errorf("expected a fallback to the private IP, got %v", h)
+	}
+
+	vars := hostInventory(inv, parsePreference("private"), "i-1")
+	if vars["ansible_host"] != "10.0.0.5" {
+		t.Errorf("expected the private IP when preferred, got %v", vars["ansible_host"])
+	}
+	if vars := hostInventory(inv, parsePreference(""), "web"); len(vars) != 0 {
+		t.Errorf("expected no variables for a name shared by two hosts, got %v", vars)
+	}
+}
@@ -0,0 +1,58 @@
+// Command hypercloud-inventory is an Ansible dynamic inventory for a HyperCloud
+// account. Ansible runs it with --list (or --host <name>), so it is configured
+// through the environment:
+//
+//	HYPERCLOUD_URL            API base URL
+//	HYPERCLOUD_TOKEN          API token
+//	HYPERCLOUD_ANSIBLE_HOST   address kinds to set ansible_host to, in order of
+//	                          preference (default "public,private")
+//
+// For example: ansible-inventory -i hypercloud-inventory --graph
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+)
+
+func main() {
+	list := flag.Bool("list", false, "Print the whole inventory")
+	host := flag.String("host", "", "Print the variables of one host")
+	flag.Parse()
+	if !*list && *host == "" {
+		fmt.Fprintln(os.Stderr, "usage: hypercloud-inventory --list | --host <name>")
+		os.Exit(2)
+	}
+
+	url, token := os.Getenv("HYPERCLOUD_URL"), os.Getenv("HYPERCLOUD_TOKEN")
+	if url == "" || token == "" {
+		fmt.Fprintln(os.Stderr, "HYPERCLOUD_URL and HYPERCLOUD_TOKEN must be set")
+		os.Exit(2)
+	}
+	hc, _ := hypercloud.NewHypercloud(url, token)
+	inv, err := hc.Inventory()
+	if err != nil {
+		for _, e := range err {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		os.Exit(1)
+	}
+
+	pref := parsePreference(os.Getenv("HYPERCLOUD_ANSIBLE_HOST"))
+	var out map[string]interface{}
+	if *list {
+		out = listInventory(inv, pref)
+	} else {
+		out = hostInventory(inv, pref, *host)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,169 @@
+package hypercloud
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	Json "encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An audit trail of every create, update and delete the client attempts. Calls
+// blocked by a policy are recorded with status 0 and the policy's error, calls
+// swallowed by dry run mode never reach the API and are not recorded.
+
+type AuditRecord struct {
+	Time       time.Time   `json:"time"`
+	Caller     string      `json:"caller"`
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Body       interface{} `json:"body,omitempty"`
+	Status     int         `json:"status"`
+	ResourceId string      `json:"resource_id,omitempty"`
+	DurationMs float64     `json:"duration_ms"`
+	Error      string      `json:"error,omitempty"` //why the call wasn't sent
+}
+
+type AuditSink interface {
+	Audit(rec AuditRecord) error
+}
+
+// Body keys whose values are replaced with "[redacted]" before they are recorded
+var AuditRedactKeys = []string{"password", "secret", "token", "private_key", "credentials", "user_data"}
+
+type auditState struct {
+	sink    AuditSink
+	onError func(error)
+	caller  string
+}
+
+// Records every mutating call (and every one a policy denied) to sink. Failures to record can't fail a call
+// that has already been made, so they are handed to onError (if set) instead.
+func (h *hypercloud) SetAuditSink(sink AuditSink, onError func(error)) {
+	if sink == nil {
+		h.audit = nil
+		return
+	}
+	h.audit = &auditState{sink: sink, onError: onError, caller: tokenSubject(h.token)}
+}
+
+func (h *hypercloud) auditRequest(method string, url string, data interface{}, json interface{}, status int, took time.Duration, denied error) {
+	rec := AuditRecord{
+		Time:       time.Now().UTC(),
+		Caller:     h.audit.caller,
+		Method:     method,
+		Path:       url,
+		Body:       redact(data),
+		Status:     status,
+		DurationMs: float64(took) / float64(time.Millisecond),
+	}
+	if denied != nil {
+		rec.Error = denied.Error()
+	}
+	if rec.ResourceId = stringOf(json, "id"); rec.ResourceId == "" {
+		_, rec.ResourceId = parseResourcePath(url)
+	}
+	if err := h.audit.sink.Audit(rec); err != nil && h.audit.onError != nil {
+		h.audit.onError(err)
+	}
+}
+
+// Who the token belongs to: the "sub" claim if it's a JWT, otherwise a short
+// hash so different tokens can be told apart without the token ending up in the log.
+func tokenSubject(token string) string {
+	if parts := strings.Split(token, "."); len(parts) == 3 {
+		if payload, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+			var claims map[string]interface{}
+			if Json.Unmarshal(payload, &claims) == nil {
+				if sub := stringOf(claims, "sub"); sub != "" {
+					return sub
+				}
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// A copy of the body with sensitive values replaced
+func redact(data interface{}) interface{} {
+	if data == nil {
+		return nil
+	}
+	var body interface{}
+	encoded, err := Json.Marshal(data)
+	if err != nil || Json.Unmarshal(encoded, &body) != nil {
+		return "[unencodable]"
+	}
+	return redactValue(body)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, inner := range val {
+			sensitive := false
+			for _, r := range AuditRedactKeys {
+				if strings.Contains(strings.ToLower(k), r) {
+					sensitive = true
+					break
+				}
+			}
+			if sensitive {
+				val[k] = "[redacted]"
+			} else {
+				val[k] = redactValue(inner)
+			}
+		}
+	case []interface{}:
+		for i := range val {
+			val[i] = redactValue(val[i])
+		}
+	}
+	return v
+}
+
+// Writes one JSON object per line to an io.Writer
+type AuditWriter struct {
+	mu  sync.Mutex
+	enc *Json.Encoder
+}
+
+func NewAuditWriter(w io.Writer) *AuditWriter {
+	return &AuditWriter{enc: Json.NewEncoder(w)}
+}
+
+func (a *AuditWriter) Audit(rec AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.enc.Encode(rec)
+}
+
+// A JSON lines file, appended to and synced after every record
+type AuditFile struct {
+	AuditWriter
+	f *os.File
+}
+
+func OpenAuditFile(path string) (*AuditFile, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditFile{AuditWriter{enc: Json.NewEncoder(f)}, f}, nil
+}
+
+func (a *AuditFile) Audit(rec AuditRecord) error {
+	if err := a.AuditWriter.Audit(rec); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+func (a *AuditFile) Close() error {
+	return a.f.Close()
+}
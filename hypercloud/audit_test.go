@@ -0,0 +1,66 @@
+package hypercloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAuditRecordsMutatingCalls(t *testing.T) {
+	cloud := newFakeCloud()
+	disk := cloud.addDisk("data")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	out := &bytes.Buffer{}
+	hc.SetAuditSink(NewAuditWriter(out), func(err error) { t.Errorf("Audit failed: %v", err) })
+
+	hc.DiskInfo(disk)
+	clone, _ := hc.DiskClone(disk, map[string]interface{}{"name": "copy", "secret_token": "hunter2"})
+	hc.DiskDelete(disk)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 audit records (GETs aren't audited), got:\n%s", out)
+	}
+	var rec AuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Method != "POST" || rec.Path != "/disks/"+disk+"/clone" || rec.Status != 200 || rec.ResourceId != idOf(clone) {
+		t.Errorf("Unexpected record: %+v", rec)
+	}
+	if rec.Caller != tokenSubject("test-token") || strings.Contains(rec.Caller, "test-token") {
+		t.Errorf("Unexpected caller %q", rec.Caller)
+	}
+	if strings.Contains(lines[0], "hunter2") || stringOf(rec.Body, "secret_token") != "[redacted]" || stringOf(rec.Body, "name") != "copy" {
+		t.Errorf("Body not redacted properly: %s", lines[0])
+	}
+	json.Unmarshal([]byte(lines[1]), &rec)
+	if rec.Method != "DELETE" || rec.ResourceId != disk {
+		t.Errorf("Unexpected record: %+v", rec)
+	}
+}
+
+func TestAuditRecordsDeniedCalls(t *testing.T) {
+	cloud := newFakeCloud()
+	inst := cloud.addInstance("web", InstanceStateStopped)
+	cloud.instances[inst]["context"] = map[string]interface{}{"protected": "true"}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	out := &bytes.Buffer{}
+	hc.SetAuditSink(NewAuditWriter(out), nil)
+	hc.AddPolicy(DenyDeleteProtected("protected"))
+
+	if _, err := hc.InstanceDelete(inst); err == nil {
+		t.Fatalf("Expected the delete to be denied")
+	}
+	var rec AuditRecord
+	if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+		t.Fatalf("Expected one audit record, got %q: %v", out, err)
+	}
+	if rec.Method != "DELETE" || rec.Status != 0 || rec.ResourceId != inst || !strings.Contains(rec.Error, "deny-delete-protected") {
+		t.Errorf("Unexpected record: %+v", rec)
+	}
+	if _, ok := cloud.instances[inst]; !ok {
+		t.Errorf("Denied delete reached the API")
+	}
+}
@@ -0,0 +1,177 @@
+package hypercloud
+
+import (
+	"fmt"
+)
+
+func (h *hypercloud) AvailabilityGroupCreate(body interface{}) (json interface{}, err []error) {
+	return h.Request("POST", "/availability_groups", body)
+}
+
+func (h *hypercloud) AvailabilityGroupDelete(groupId string) (json interface{}, err []error) {
+	return h.Request("DELETE", "/availability_groups/"+groupId, nil)
+}
+
+func (h *hypercloud) AvailabilityGroupInfo(groupId string) (json interface{}, err []error) {
+	return h.Request("GET", "/availability_groups/"+groupId, nil)
+}
+
+func (h *hypercloud) AvailabilityGroupList() (json interface{}, err []error) {
+	return h.Request("GET", "/availability_groups", nil)
+}
+
+func (h *hypercloud) AvailabilityGroupUpdate(groupId string, body interface{}) (json interface{}, err []error) {
+	return h.Request("PUT", "/availability_groups/"+groupId, body)
+}
+
+// The availability groups an instance belongs to
+func (h *hypercloud) InstanceAvailabilityGroups(instanceId string) (groups []string, err []error) {
+	info, err := h.InstanceInfo(instanceId)
+	if err != nil {
+		return
+	}
+	for _, g := range sliceOf(mapOf(info)["availability_groups"]) {
+		groups = append(groups, idOf(g))
+	}
+	if g := idOf(mapOf(info)["availability_group"]); g != "" && indexOf(groups, g) < 0 {
+		groups = append(groups, g)
+	}
+	return
+}
+
+// Places the instances round robin across the groups so that no two instances
+// share a group until every group has one. Instances already in one of the
+// groups stay where they are if that keeps the spread even. Memberships of
+// groups other than groupIds are left alone. Returns the group each instance
+// ended up in.
+func (h *hypercloud) AvailabilityGroupSpread(instanceIds []string, groupIds []string) (placement map[string]string, err []error) {
+	if len(groupIds) == 0 {
+		err = append(err, fmt.Errorf("No availability groups to spread across"))
+		return
+	}
+	// An even spread: every group holds base instances, extra of them one more
+	base, extra := len(instanceIds)/len(groupIds), len(instanceIds)%len(groupIds)
+	full := 0 //groups holding base+1
+	load := make(map[string]int)
+	placement = make(map[string]string)
+	current := make(map[string][]string)
+	var unplaced []string
+
+	for _, instanceId := range instanceIds {
+		groups, erro := h.InstanceAvailabilityGroups(instanceId)
+		if erro != nil {
+			err = append(err, erro...)
+			continue
+		}
+		current[instanceId] = groups
+		kept := false
+		for _, g := range groups {
+			if indexOf(groupIds, g) < 0 || load[g] > base || (load[g] == base && full >= extra) {
+				continue
+			}
+			if load[g] == base {
+				full++
+			}
+			placement[instanceId] = g
+			load[g]++
+			kept = true
+			break
+		}
+		if !kept {
+			unplaced = append(unplaced, instanceId)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	for _, instanceId := range unplaced {
+		// Least loaded group, ties broken by the order given
+		best := groupIds[0]
+		for _, g := range groupIds {
+			if load[g] < load[best] {
+				best = g
+			}
+		}
+		placement[instanceId] = best
+		load[best]++
+	}
+
+	for _, instanceId := range instanceIds {
+		// Other groups are kept, of ours only the one it was placed in
+		want := []string{}
+		for _, g := range current[instanceId] {
+			if indexOf(groupIds, g) < 0 {
+				want = append(want, g)
+			}
+		}
+		want = append(want, placement[instanceId])
+		if sameMembers(want, current[instanceId]) {
+			continue
+		}
+		if _, erro := h.InstanceUpdateHighAvailability(instanceId, map[string]interface{}{"availability_groups": want}); erro != nil {
+			err = append(err, erro...)
+			delete(placement, instanceId)
+		}
+	}
+	if err == nil {
+		err = h.AvailabilityGroupVerify(instanceIds, groupIds)
+	}
+	return
+}
+
+func sameMembers(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, s := range a {
+		if indexOf(b, s) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks anti-affinity for a set of instances: every instance is in one of the
+// groups and they are spread evenly, i.e. no group holds more than one instance
+// more than any other (so no two share a group while another is empty). Each
+// violation is one error.
+func (h *hypercloud) AvailabilityGroupVerify(instanceIds []string, groupIds []string) (err []error) {
+	if len(groupIds) == 0 {
+		return []error{fmt.Errorf("No availability groups to verify against")}
+	}
+	members := make(map[string][]string)
+	for _, instanceId := range instanceIds {
+		current, erro := h.InstanceAvailabilityGroups(instanceId)
+		if erro != nil {
+			err = append(err, erro...)
+			continue
+		}
+		var in []string
+		for _, g := range current {
+			if indexOf(groupIds, g) >= 0 {
+				in = append(in, g)
+			}
+		}
+		switch {
+		case len(in) == 0:
+			err = append(err, fmt.Errorf("Instance %s is not in any of the availability groups %v", instanceId, groupIds))
+		case len(in) > 1:
+			err = append(err, fmt.Errorf("Instance %s is in more than one of the availability groups: %v", instanceId, in))
+		default:
+			members[in[0]] = append(members[in[0]], instanceId)
+		}
+	}
+	least := groupIds[0]
+	for _, g := range groupIds {
+		if len(members[g]) < len(members[least]) {
+			least = g
+		}
+	}
+	for _, g := range groupIds {
+		if len(members[g]) > len(members[least])+1 {
+			err = append(err, fmt.Errorf("Availability group %s holds %d of the instances (%v) while %s holds %d", g, len(members[g]), members[g], least, len(members[least])))
+		}
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package hypercloud
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAvailabilityGroupSpread(t *testing.T) {
+	cloud := newFakeCloud()
+	a := cloud.addInstance("a", InstanceStateRunning)
+	b := cloud.addInstance("b", InstanceStateRunning)
+	c := cloud.addInstance("c", InstanceStateRunning)
+	cloud.instances[a]["availability_groups"] = []interface{}{"ag-1", "ag-other"}
+	cloud.instances[b]["availability_groups"] = []interface{}{"ag-1", "ag-2"}
+	cloud.instances[c]["availability_groups"] = []interface{}{"ag-backups"}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	if err := hc.AvailabilityGroupVerify([]string{a, b, c}, []string{"ag-1", "ag-2", "ag-3"}); len(err) != 2 {
+		t.Errorf("Expected b being in two groups and c in none to be reported, got %v", err)
+	}
+
+	placement, err := hc.AvailabilityGroupSpread([]string{a, b, c}, []string{"ag-1", "ag-2", "ag-3"})
+	if err != nil {
+		t.Fatalf("Spread failed: %v", err)
+	}
+	want := map[string]string{a: "ag-1", b: "ag-2", c: "ag-3"}
+	if !reflect.DeepEqual(placement, want) {
+		t.Errorf("Expected %v, got %v", want, placement)
+	}
+	groups := func(id string) []string {
+		g, _ := hc.InstanceAvailabilityGroups(id)
+		return g
+	}
+	if g := groups(a); !reflect.DeepEqual(g, []string{"ag-1", "ag-other"}) {
+		t.Errorf("Expected a to be left alone, got %v", g)
+	}
+	if g := groups(b); !reflect.DeepEqual(g, []string{"ag-2"}) {
+		t.Errorf("Expected b to leave ag-1, got %v", g)
+	}
+	if g := groups(c); !reflect.DeepEqual(g, []string{"ag-backups", "ag-3"}) {
+		t.Errorf("Expected c to keep its other group, got %v", g)
+	}
+	for _, call := range cloud.calls {
+		if call == "PUT /instances/"+a+"/availability_group" {
+			t.Errorf("Expected no update for an instance already placed")
+		}
+	}
+}
+
+func TestAvailabilityGroupUneven(t *testing.T) {
+	cloud := newFakeCloud()
+	groups := []string{"ag-1", "ag-2", "ag-3"}
+	var ids []string
+	for i, g := range []string{"ag-1", "ag-1", "ag-2", "ag-2"} {
+		id := cloud.addInstance(fmt.Sprintf("web-%d", i), InstanceStateRunning)
+		cloud.instances[id]["availability_groups"] = []interface{}{g}
+		ids = append(ids, id)
+	}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	// 2/2/0: two share a group while ag-3 is empty
+	if err := hc.AvailabilityGroupVerify(ids, groups); len(err) != 2 {
+		t.Errorf("Expected ag-1 and ag-2 to be reported against the empty ag-3, got %v", err)
+	}
+
+	placement, err := hc.AvailabilityGroupSpread(ids, groups)
+	if err != nil {
+		t.Fatalf("Spread failed: %v", err)
+	}
+	load := make(map[string]int)
+	for _, g := range placement {
+		load[g]++
+	}
+	if want := map[string]int{"ag-1": 2, "ag-2": 1, "ag-3": 1}; !reflect.DeepEqual(load, want) {
+		t.Errorf("Expected a 2/1/1 spread, got %v", placement)
+	}
+	// Only one group may hold the extra instance, the first to claim it keeps it
+	if placement[ids[1]] != "ag-1" || placement[ids[2]] != "ag-2" || placement[ids[3]] != "ag-3" {
+		t.Errorf("Expected only the last instance to move, got %v", placement)
+	}
+	if err := hc.AvailabilityGroupVerify(ids, groups); err != nil {
+		t.Errorf("Expected the spread to verify, got %v", err)
+	}
+}
@@ -0,0 +1,442 @@
+package hypercloud
+
+import (
+	Json "encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Which disks to back up, how often and how many copies to keep
+type BackupPolicy struct {
+	Name        string   //used in backup names, keep it short and dns-ish
+	DiskPattern string   //glob matched against disk names (path.Match syntax)
+	InstanceIds []string //every disk attached to these instances
+	Interval    time.Duration
+	Retain      int //copies kept per disk, 0 keeps everything
+
+	PerformanceTier string //tier for the copies, defaults to the source's
+}
+
+const (
+	BackupPending  = "pending"
+	BackupComplete = "complete"
+	BackupFailed   = "failed"
+)
+
+// One backup copy as recorded in the catalog
+type BackupEntry struct {
+	Policy     string    `json:"policy"`
+	SourceId   string    `json:"source_id"`
+	SourceName string    `json:"source_name"`
+	Name       string    `json:"name"`
+	DiskId     string    `json:"disk_id"`
+	Slot       time.Time `json:"slot"`
+	State      string    `json:"state"`
+	Error      string    `json:"error,omitempty"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished,omitempty"`
+}
+
+// Runs backup policies and keeps a catalog of every copy on local disk. The
+// catalog is written before each clone is started, so a run interrupted by a
+// crash picks up where it left off the next time round.
+type BackupManager struct {
+	hc          *hypercloud
+	catalogPath string
+	policies    []BackupPolicy
+
+	// How often Run checks for due backups, defaults to a minute
+	CheckInterval time.Duration
+	// Called for every backup that completes or fails
+	OnBackup func(entry BackupEntry)
+
+	mu      sync.Mutex
+	entries []BackupEntry
+}
+
+func (h *hypercloud) NewBackupManager(catalogPath string, policies ...BackupPolicy) (m *BackupManager, err []error) {
+	for _, p := range policies {
+		if p.Name == "" || p.Interval <= 0 {
+			err = append(err, fmt.Errorf("Backup policy %q needs a name and a positive interval", p.Name))
+		}
+		if p.DiskPattern == "" && len(p.InstanceIds) == 0 {
+			err = append(err, fmt.Errorf("Backup policy %q selects no disks", p.Name))
+		}
+		if _, erro := path.Match(p.DiskPattern, ""); erro != nil {
+			err = append(err, fmt.Errorf("Backup policy %q: %v", p.Name, erro))
+		}
+	}
+	if err != nil {
+		return
+	}
+	m = &BackupManager{hc: h, catalogPath: catalogPath, policies: policies, CheckInterval: time.Minute}
+	if erro := m.load(); erro != nil {
+		err = append(err, erro)
+		m = nil
+	}
+	return
+}
+
+// A copy of the catalog
+func (m *BackupManager) Entries() []BackupEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]BackupEntry(nil), m.entries...)
+}
+
+// Runs due backups every CheckInterval until stop is closed. Errors from a
+// round are passed to onError (if set) and the next round carries on.
+func (m *BackupManager) Run(stop <-chan struct{}, onError func([]error)) {
+	for {
+		if err := m.RunOnce(time.Now()); err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(m.CheckInterval):
+		}
+	}
+}
+
+// Finishes any backups interrupted earlier, takes every backup due at now and prunes old copies
+func (m *BackupManager) RunOnce(now time.Time) (err []error) {
+	err = append(err, m.resume()...)
+	for _, p := range m.policies {
+		disks, erro := m.policyDisks(p)
+		if erro != nil {
+			err = append(err, erro...)
+			continue
+		}
+		slot := now.UTC().Truncate(p.Interval)
+		for _, d := range disks {
+			if m.hasBackup(p.Name, idOf(d), slot) {
+				continue
+			}
+			err = append(err, m.backup(p, d, slot)...)
+		}
+		err = append(err, m.prune(p)...)
+	}
+	if len(err) == 0 {
+		err = nil
+	}
+	return
+}
+
+// Names are derived from the policy, disk and slot so retries land on the same
+// name. Disk names needn't be unique, so a short hash of the source id is included.
+func backupName(policy string, diskName string, diskId string, slot time.Time) string {
+	return fmt.Sprintf("%s-backup-%s-%s-%s", diskName, policy, shortHash(diskId), slot.UTC().Format(snapshotTimeFormat))
+}
+
+// Backups and snapshots carry a context saying what they are a copy of. Where
+// the API keeps no context for disks the name has to do, and only a full
+// backup or snapshot name counts.
+var backupNamePattern = regexp.MustCompile(`-backup-.+-[0-9a-f]{8}-[0-9]{8}t[0-9]{6}$`)
+
+func isCopy(disk interface{}) bool {
+	kind, _, hasContext := copyMarker(disk)
+	if hasContext {
+		return kind != ""
+	}
+	name := stringOf(disk, "name")
+	_, snapshot := parseSnapshotName(name)
+	return snapshot || backupNamePattern.MatchString(name)
+}
+
+func (m *BackupManager) policyDisks(p BackupPolicy) (disks []interface{}, err []error) {
+	seen := make(map[string]bool)
+	if p.DiskPattern != "" {
+		list, erro := m.hc.DiskList()
+		if erro != nil {
+			return nil, erro
+		}
+		for _, d := range sliceOf(list) {
+			name := stringOf(d, "name")
+			// Never back up our own copies (or snapshots), even if the pattern would match them
+			if isCopy(d) || seen[idOf(d)] {
+				continue
+			}
+			if ok, _ := path.Match(p.DiskPattern, name); ok {
+				seen[idOf(d)] = true
+				disks = append(disks, d)
+			}
+		}
+	}
+	for _, instanceId := range p.InstanceIds {
+		info, erro := m.hc.InstanceInfo(instanceId)
+		if erro != nil {
+			err = append(err, erro...)
+			continue
+		}
+		for _, d := range sliceOf(mapOf(info)["disks"]) {
+			if seen[idOf(d)] {
+				continue
+			}
+			// Instances may only reference their disks by id
+			if mapOf(d) == nil || stringOf(d, "name") == "" {
+				var erro []error
+				if d, erro = m.hc.DiskInfo(idOf(d)); erro != nil {
+					err = append(err, erro...)
+					continue
+				}
+			}
+			seen[idOf(d)] = true
+			disks = append(disks, d)
+		}
+	}
+	return
+}
+
+func (m *BackupManager) hasBackup(policy string, sourceId string, slot time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if e.Policy == policy && e.SourceId == sourceId && e.Slot.Equal(slot) && e.State != BackupFailed {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *BackupManager) backup(p BackupPolicy, disk interface{}, slot time.Time) (err []error) {
+	entry := BackupEntry{
+		Policy:     p.Name,
+		SourceId:   idOf(disk),
+		SourceName: stringOf(disk, "name"),
+		Slot:       slot,
+		State:      BackupPending,
+		Started:    time.Now().UTC(),
+	}
+	entry.Name = backupName(p.Name, entry.SourceName, entry.SourceId, slot)
+	if prev, ok := m.entry(p.Name, entry.SourceId, slot); ok && prev.DiskId != "" {
+		done, erro := m.retry(prev, entry)
+		if done || erro != nil {
+			return erro
+		}
+	}
+	if erro := m.record(entry); erro != nil {
+		return []error{erro}
+	}
+	clone, err := m.hc.diskCloneStart(entry.SourceId, DiskCloneOptions{Name: entry.Name, PerformanceTier: p.PerformanceTier, Context: CopyContext("backup", entry.SourceId)})
+	if err != nil {
+		m.finish(entry, err)
+		return
+	}
+	entry.DiskId = idOf(clone)
+	if erro := m.record(entry); erro != nil {
+		return []error{erro}
+	}
+	_, err = m.hc.diskWaitSettled(entry.DiskId, 0, nil)
+	m.finish(entry, err)
+	return
+}
+
+// A failed attempt whose clone was started (and then timed out) is either
+// waited on again and kept, or deleted so it doesn't linger untracked. done
+// says whether the backup was completed, otherwise a fresh clone is needed.
+func (m *BackupManager) retry(prev BackupEntry, entry BackupEntry) (done bool, err []error) {
+	list, err := m.hc.DiskList()
+	if err != nil {
+		return
+	}
+	exists := false
+	for _, d := range sliceOf(list) {
+		if idOf(d) == prev.DiskId {
+			exists = true
+			break
+		}
+	}
+	if !exists {
+		return
+	}
+	entry.DiskId = prev.DiskId
+	if erro := m.record(entry); erro != nil {
+		return false, []error{erro}
+	}
+	if _, erro := m.hc.diskWaitSettled(entry.DiskId, 0, nil); erro == nil {
+		m.finish(entry, nil)
+		return true, nil
+	}
+	if _, err = m.hc.DiskDelete(entry.DiskId); err != nil {
+		// Keep the id so the next round tries again rather than cloning beside it
+		m.finish(entry, err)
+		return
+	}
+	entry.DiskId = ""
+	return false, nil
+}
+
+// Picks up pending entries left behind by a crash: the copy is looked up by its
+// name and waited on if it exists, otherwise it is marked failed so it gets retaken.
+func (m *BackupManager) resume() (err []error) {
+	var pending []BackupEntry
+	for _, e := range m.Entries() {
+		if e.State == BackupPending {
+			pending = append(pending, e)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	list, err := m.hc.DiskList()
+	if err != nil {
+		return
+	}
+	for _, e := range pending {
+		if e.DiskId == "" {
+			for _, d := range sliceOf(list) {
+				if stringOf(d, "name") == e.Name {
+					e.DiskId = idOf(d)
+					break
+				}
+			}
+		}
+		if e.DiskId == "" {
+			// Never got as far as the clone, let the next round retake it
+			e.State = BackupFailed
+			e.Error = "interrupted before the clone was started"
+			if erro := m.record(e); erro != nil {
+				err = append(err, erro)
+			}
+			continue
+		}
+		_, erro := m.hc.diskWaitSettled(e.DiskId, 0, nil)
+		m.finish(e, erro)
+		err = append(err, erro...)
+	}
+	return
+}
+
+func (m *BackupManager) finish(entry BackupEntry, err []error) {
+	entry.Finished = time.Now().UTC()
+	entry.State = BackupComplete
+	if err != nil {
+		entry.State = BackupFailed
+		entry.Error = fmt.Sprintf("%v", err)
+	}
+	m.record(entry)
+	if m.OnBackup != nil {
+		m.OnBackup(entry)
+	}
+}
+
+// Deletes the oldest completed copies beyond the policy's retention
+func (m *BackupManager) prune(p BackupPolicy) (err []error) {
+	if p.Retain <= 0 {
+		return
+	}
+	bySource := make(map[string][]BackupEntry)
+	for _, e := range m.Entries() {
+		if e.Policy == p.Name && e.State == BackupComplete {
+			bySource[e.SourceId] = append(bySource[e.SourceId], e)
+		}
+	}
+	for _, entries := range bySource {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Slot.After(entries[j].Slot) })
+		if len(entries) <= p.Retain {
+			continue
+		}
+		for _, e := range entries[p.Retain:] {
+			if _, erro := m.hc.DiskDelete(e.DiskId); erro != nil {
+				err = append(err, erro...)
+				continue
+			}
+			if erro := m.forget(e); erro != nil {
+				err = append(err, erro)
+			}
+		}
+	}
+	return
+}
+
+// Entries are identified by policy, source disk and slot
+func (e BackupEntry) same(other BackupEntry) bool {
+	return e.Policy == other.Policy && e.SourceId == other.SourceId && e.Slot.Equal(other.Slot)
+}
+
+func (m *BackupManager) entry(policy string, sourceId string, slot time.Time) (BackupEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if e.same(BackupEntry{Policy: policy, SourceId: sourceId, Slot: slot}) {
+			return e, true
+		}
+	}
+	return BackupEntry{}, false
+}
+
+// Adds or replaces the entry and saves the catalog
+func (m *BackupManager) record(entry BackupEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replaced := false
+	for i := range m.entries {
+		if m.entries[i].same(entry) {
+			m.entries[i] = entry
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		m.entries = append(m.entries, entry)
+	}
+	return m.save()
+}
+
+func (m *BackupManager) forget(entry BackupEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.entries {
+		if m.entries[i].same(entry) {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			break
+		}
+	}
+	return m.save()
+}
+
+func (m *BackupManager) load() error {
+	data, err := os.ReadFile(m.catalogPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = Json.Unmarshal(data, &m.entries); err != nil {
+		return fmt.Errorf("Unable to read backup catalog %s: %v", m.catalogPath, err)
+	}
+	return nil
+}
+
+// Written to a temp file and renamed into place so a crash never leaves half a catalog
+func (m *BackupManager) save() error {
+	data, err := Json.MarshalIndent(m.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.catalogPath), filepath.Base(m.catalogPath)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.catalogPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package hypercloud
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func countBackups(cloud *fakeCloud) (n int) {
+	cloud.Lock()
+	defer cloud.Unlock()
+	for _, d := range cloud.disks {
+		if strings.Contains(d["name"].(string), "-backup-") {
+			n++
+		}
+	}
+	return
+}
+
+func TestBackupManagerRetention(t *testing.T) {
+	pollInterval = time.Millisecond
+	cloud := newFakeCloud()
+	db := cloud.addDisk("db-data")
+	cloud.addDisk("web-data")
+	cloud.addDisk("scratch")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	policy := BackupPolicy{Name: "hourly", DiskPattern: "*-data", Interval: time.Hour, Retain: 2}
+	m, err := hc.NewBackupManager(filepath.Join(t.TempDir(), "catalog.json"), policy)
+	if err != nil {
+		t.Fatalf("Unable to create backup manager: %v", err)
+	}
+
+	start := time.Date(2017, 3, 1, 12, 5, 0, 0, time.UTC)
+	for _, at := range []time.Time{start, start.Add(10 * time.Minute), start.Add(time.Hour), start.Add(2 * time.Hour)} {
+		if err := m.RunOnce(at); err != nil {
+			t.Fatalf("Backup run at %v failed: %v", at, err)
+		}
+	}
+
+	if n := countBackups(cloud); n != 4 {
+		t.Errorf("Expected 2 copies of each of the 2 disks, found %d", n)
+	}
+	entries := m.Entries()
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 catalog entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.State != BackupComplete {
+			t.Errorf("Backup %s is %s", e.Name, e.State)
+		}
+		if e.Slot.Equal(start.Truncate(time.Hour)) {
+			t.Errorf("Oldest backup %s should have been pruned", e.Name)
+		}
+	}
+	if want := "db-data-backup-hourly-" + shortHash(db) + "-20170301t140000"; !cloudHasDisk(cloud, want) {
+		t.Errorf("Expected a disk named %s", want)
+	}
+}
+
+func cloudHasDisk(cloud *fakeCloud, name string) bool {
+	cloud.Lock()
+	defer cloud.Unlock()
+	for _, d := range cloud.disks {
+		if d["name"] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBackupManagerResumes(t *testing.T) {
+	pollInterval = time.Millisecond
+	cloud := newFakeCloud()
+	source := cloud.addDisk("db-data")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	catalog := filepath.Join(t.TempDir(), "catalog.json")
+	policy := BackupPolicy{Name: "daily", DiskPattern: "db-data", Interval: 24 * time.Hour}
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	slot := now.Truncate(24 * time.Hour)
+
+	// Simulate a crash straight after the clone was requested, before its id was recorded
+	m, _ := hc.NewBackupManager(catalog, policy)
+	name := backupName("daily", "db-data", source, slot)
+	m.record(BackupEntry{Policy: "daily", SourceId: source, SourceName: "db-data", Name: name, Slot: slot, State: BackupPending})
+	cloud.addDisk(name)
+
+	m, err := hc.NewBackupManager(catalog, policy)
+	if err != nil {
+		t.Fatalf("Unable to reload backup manager: %v", err)
+	}
+	if err := m.RunOnce(now); err != nil {
+		t.Fatalf("Backup run failed: %v", err)
+	}
+	entries := m.Entries()
+	if len(entries) != 1 || entries[0].State != BackupComplete || entries[0].DiskId == "" {
+		t.Errorf("Expected the interrupted backup to be completed, got %+v", entries)
+	}
+	if n := countBackups(cloud); n != 1 {
+		t.Errorf("Expected the existing copy to be reused, found %d copies", n)
+	}
+}
+
+func TestBackupManagerSameNamedDisks(t *testing.T) {
+	pollInterval = time.Millisecond
+	cloud := newFakeCloud()
+	cloud.addDisk("data")
+	cloud.addDisk("data")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	policy := BackupPolicy{Name: "daily", DiskPattern: "data", Interval: 24 * time.Hour}
+	m, _ := hc.NewBackupManager(filepath.Join(t.TempDir(), "catalog.json"), policy)
+
+	if err := m.RunOnce(time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Backup run failed: %v", err)
+	}
+	entries := m.Entries()
+	if len(entries) != 2 || entries[0].Name == entries[1].Name || entries[0].SourceId == entries[1].SourceId {
+		t.Errorf("Expected a separately named entry per disk, got %+v", entries)
+	}
+	if n := countBackups(cloud); n != 2 {
+		t.Errorf("Expected 2 copies, found %d", n)
+	}
+}
+
+func TestBackupManagerRetriesFailed(t *testing.T) {
+	pollInterval = time.Millisecond
+	cloud := newFakeCloud()
+	source := cloud.addDisk("db-data")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	catalog := filepath.Join(t.TempDir(), "catalog.json")
+	policy := BackupPolicy{Name: "daily", DiskPattern: "db-data", Interval: 24 * time.Hour}
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	slot := now.Truncate(24 * time.Hour)
+	name := backupName("daily", "db-data", source, slot)
+
+	// The clone was started but timed out, and has since finished
+	m, _ := hc.NewBackupManager(catalog, policy)
+	copyId := cloud.addDisk(name)
+	m.record(BackupEntry{Policy: "daily", SourceId: source, SourceName: "db-data", Name: name, Slot: slot, DiskId: copyId, State: BackupFailed})
+	if err := m.RunOnce(now); err != nil {
+		t.Fatalf("Backup run failed: %v", err)
+	}
+	entries := m.Entries()
+	if len(entries) != 1 || entries[0].State != BackupComplete || entries[0].DiskId != copyId {
+		t.Errorf("Expected the earlier copy to be kept, got %+v", entries)
+	}
+	if n := countBackups(cloud); n != 1 {
+		t.Errorf("Expected no second copy, found %d", n)
+	}
+
+	// The copy from the failed attempt has gone, so it's taken again
+	m, _ = hc.NewBackupManager(filepath.Join(t.TempDir(), "catalog.json"), policy)
+	m.record(BackupEntry{Policy: "daily", SourceId: source, SourceName: "db-data", Name: name, Slot: slot, DiskId: "disk-gone", State: BackupFailed})
+	if err := m.RunOnce(now); err != nil {
+		t.Fatalf("Backup run failed: %v", err)
+	}
+	for _, e := range m.Entries() {
+		if e.Slot.Equal(slot) && (e.State != BackupComplete || e.DiskId == "disk-gone" || e.DiskId == "") {
+			t.Errorf("Expected a fresh copy to be taken, got %+v", e)
+		}
+	}
+}
+
+func TestBackupManagerSkipsCopies(t *testing.T) {
+	pollInterval = time.Millisecond
+	cloud := newFakeCloud()
+	plan := cloud.addDisk("my-backup-plan")
+	data := cloud.addDisk("data")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	snap, err := hc.DiskSnapshotCreate(data, "", 0, DiskCloneOptions{})
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	// An old copy made where disks had no context, known by its name alone
+	cloud.addDisk("data-backup-hourly-" + shortHash(data) + "-20170301t110000")
+
+	policy := BackupPolicy{Name: "hourly", DiskPattern: "*", Interval: time.Hour, Retain: 5}
+	m, err := hc.NewBackupManager(filepath.Join(t.TempDir(), "catalog.json"), policy)
+	if err != nil {
+		t.Fatalf("Unable to create backup manager: %v", err)
+	}
+	start := time.Date(2017, 3, 1, 12, 5, 0, 0, time.UTC)
+	for _, at := range []time.Time{start, start.Add(time.Hour)} {
+		if err := m.RunOnce(at); err != nil {
+			t.Fatalf("Backup run at %v failed: %v", at, err)
+		}
+	}
+
+	sources := make(map[string]int)
+	for _, e := range m.Entries() {
+		sources[e.SourceId]++
+	}
+	if len(sources) != 2 || sources[plan] != 2 || sources[data] != 2 {
+		t.Errorf("Expected only the two real disks to be backed up, twice each, got %v (snapshot %s)", sources, snap.Id)
+	}
+	for _, e := range m.Entries() {
+		if kind, source, _ := copyMarker(cloud.disks[e.DiskId]); kind != "backup" || source != e.SourceId {
+			t.Errorf("Expected backup %s to be marked as a copy of %s, got %v", e.Name, e.SourceId, cloud.disks[e.DiskId]["context"])
+		}
+	}
+}
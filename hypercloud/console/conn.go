@@ -0,0 +1,126 @@
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+var ErrSessionExpired = errors.New("console session expired")
+
+// How long to wait for the console endpoint to accept the connection
+var DialTimeout = 15 * time.Second
+
+// A live connection to an instance's console. Once the session expires the
+// connection is closed and Read/Write return ErrSessionExpired.
+type Conn struct {
+	Session *Session
+
+	rwc   io.ReadWriteCloser
+	timer *time.Timer
+
+	mu      sync.Mutex
+	expired bool
+	closed  bool
+}
+
+// Connects to the endpoint described by the session
+func Dial(s *Session) (c *Conn, err error) {
+	if s.Expired() {
+		return nil, ErrSessionExpired
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return
+	}
+	var rwc io.ReadWriteCloser
+	switch u.Scheme {
+	case "ws", "wss":
+		rwc, err = dialWebsocket(s, u)
+	case "tcp", "":
+		rwc, err = net.DialTimeout("tcp", u.Host, DialTimeout)
+	default:
+		err = fmt.Errorf("Unsupported console endpoint scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return
+	}
+	c = &Conn{Session: s, rwc: rwc}
+	if d := s.Remaining(); d >= 0 {
+		c.timer = time.AfterFunc(d, c.expire)
+	}
+	return
+}
+
+func dialWebsocket(s *Session, u *url.URL) (io.ReadWriteCloser, error) {
+	if s.Token != "" && u.Query().Get("token") == "" {
+		q := u.Query()
+		q.Set("token", s.Token)
+		u.RawQuery = q.Encode()
+	}
+	origin := "http://" + u.Host
+	if u.Scheme == "wss" {
+		origin = "https://" + u.Host
+	}
+	cfg, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Protocol = []string{"binary"}
+	cfg.Dialer = &net.Dialer{Timeout: DialTimeout}
+	ws, err := websocket.DialConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+func (c *Conn) expire() {
+	c.mu.Lock()
+	c.expired = true
+	c.mu.Unlock()
+	c.rwc.Close()
+}
+
+func (c *Conn) Expired() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expired
+}
+
+func (c *Conn) Read(p []byte) (n int, err error) {
+	n, err = c.rwc.Read(p)
+	if err != nil && c.Expired() {
+		err = ErrSessionExpired
+	}
+	return
+}
+
+func (c *Conn) Write(p []byte) (n int, err error) {
+	n, err = c.rwc.Write(p)
+	if err != nil && c.Expired() {
+		err = ErrSessionExpired
+	}
+	return
+}
+
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	return c.rwc.Close()
+}
@@ -0,0 +1,112 @@
+package console
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestDial(t *testing.T) {
+	sim := newSimulatedConsole(t, "RFB 003.008\n")
+	addr := sim.listener.Addr().String()
+
+	if _, err := Dial(&Session{URL: "tcp://" + addr, ExpiresAt: time.Now().Add(-time.Second)}); err != ErrSessionExpired {
+		t.Errorf("Expected an expired session to be refused, got %v", err)
+	}
+	if _, err := Dial(&Session{URL: "http://" + addr}); err == nil {
+		t.Errorf("Expected an unsupported scheme to be refused")
+	}
+
+	conn, err := Dial(&Session{URL: "tcp://" + addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "RFB 003.008\n" {
+		t.Errorf("Expected the console's greeting, got %q (%v)", buf, err)
+	}
+	if conn.Expired() {
+		t.Errorf("A session without an expiry time expired")
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Expected a second Close to be a no-op, got %v", err)
+	}
+}
+
+func TestConnExpires(t *testing.T) {
+	sim := newSimulatedConsole(t, "hello")
+	s := &Session{URL: "tcp://" + sim.listener.Addr().String(), ExpiresAt: time.Now().Add(100 * time.Millisecond)}
+	conn, err := Dial(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing more comes, the read is cut off by the expiry
+	start := time.Now()
+	if _, err := conn.Read(buf); err != ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second || !conn.Expired() {
+		t.Errorf("Expected the connection to be closed on expiry")
+	}
+	if _, err := conn.Write([]byte("x")); err != ErrSessionExpired {
+		t.Errorf("Expected writes to fail with ErrSessionExpired, got %v", err)
+	}
+}
+
+func TestDialWebsocket(t *testing.T) {
+	type handshake struct{ token, protocol string }
+	seen := make(chan handshake, 2)
+	server := httptest.NewServer(websocket.Server{Handler: func(ws *websocket.Conn) {
+		seen <- handshake{ws.Request().URL.Query().Get("token"), strings.Join(ws.Config().Protocol, ",")}
+		io.Copy(ws, ws)
+	}})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/console"
+
+	conn, err := Dial(&Session{URL: url, Token: "t-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected the echo, got %q (%v)", buf, err)
+	}
+	conn.Close()
+	if h := <-seen; h.token != "t-1" || h.protocol != "binary" {
+		t.Errorf("Expected the session token and the binary protocol, got %+v", h)
+	}
+
+	// A token already in the URL is left alone
+	conn, err = Dial(&Session{URL: url + "?token=from-url", Token: "t-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if h := <-seen; h.token != "from-url" {
+		t.Errorf("Expected the URL's own token, got %q", h.token)
+	}
+
+	if _, err := Dial(&Session{URL: url, ExpiresAt: time.Now().Add(-time.Second)}); err != ErrSessionExpired {
+		t.Errorf("Expected an expired session to be refused, got %v", err)
+	}
+	server.Close()
+	if _, err := Dial(&Session{URL: url}); err == nil {
+		t.Errorf("Expected dialing a closed endpoint to fail")
+	}
+}
@@ -0,0 +1,84 @@
+package console
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+// A pretend console endpoint. Every connection gets the next chunk of output and
+// is then held open until the client goes away, like a real serial console.
+type simulatedConsole struct {
+	listener net.Listener
+	chunks   []string
+
+	mu    sync.Mutex
+	conns int
+}
+
+func newSimulatedConsole(t *testing.T, chunks ...string) *simulatedConsole {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &simulatedConsole{listener: l, chunks: chunks}
+	t.Cleanup(func() { l.Close() })
+	go c.serve()
+	return c
+}
+
+func (c *simulatedConsole) connections() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conns
+}
+
+func (c *simulatedConsole) serve() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		chunk := ""
+		if c.conns < len(c.chunks) {
+			chunk = c.chunks[c.conns]
+		}
+		c.conns++
+		c.mu.Unlock()
+		go func() {
+			conn.Write([]byte(chunk))
+			buf := make([]byte, 1)
+			conn.Read(buf)
+			conn.Close()
+		}()
+	}
+}
+
+// Hands out short lived sessions pointing at the simulated console
+type fakeClient struct {
+	endpoint  string
+	expiresIn float64
+
+	mu       sync.Mutex
+	sessions int
+}
+
+func (f *fakeClient) InstanceRemoteAccess(instanceId string, body interface{}) (interface{}, []error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions++
+	host, port, _ := net.SplitHostPort(f.endpoint)
+	return map[string]interface{}{
+		"id":         fmt.Sprintf("session-%d", f.sessions),
+		"type":       body.(map[string]interface{})["type"],
+		"host":       host,
+		"port":       port,
+		"expires_in": f.expiresIn,
+	}, nil
+}
+
+func (f *fakeClient) ConsoleSessionInfo(id string) (interface{}, []error) {
+	return nil, []error{fmt.Errorf("not implemented")}
+}
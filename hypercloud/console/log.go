@@ -0,0 +1,160 @@
+package console
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Tails the text (serial) console of an instance into Out, prefixing every line
+// with the time it was received. Sessions are reopened as they expire or drop
+// so a boot can be followed from start to finish.
+type Tailer struct {
+	Client     Client
+	InstanceId string
+	Out        io.Writer
+
+	TimeFormat     string        //defaults to time.RFC3339Nano
+	ReconnectDelay time.Duration //pause between reconnect attempts, defaults to a second
+	MaxFailures    int           //consecutive failed connects before giving up, 0 for no limit
+
+	// Called every time a new session is opened (including the first one)
+	OnSession func(s *Session)
+
+	now func() time.Time
+
+	// A line cut off by the end of a session, finished by the next one
+	partial   string
+	partialAt string
+}
+
+func NewTailer(c Client, instanceId string, out io.Writer) *Tailer {
+	return &Tailer{Client: c, InstanceId: instanceId, Out: out}
+}
+
+// Follows the console until stop is closed. Returns nil when stopped, or the
+// last error once MaxFailures consecutive connection attempts have failed.
+// A line still unfinished at that point is written out as it is.
+func (t *Tailer) Run(stop <-chan struct{}) (err error) {
+	defer func() {
+		if ferr := t.flush(); err == nil {
+			err = ferr
+		}
+	}()
+	delay := t.ReconnectDelay
+	if delay == 0 {
+		delay = time.Second
+	}
+	failures := 0
+	for {
+		connected, err := t.follow(stop)
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if oerr, ok := err.(outputError); ok {
+			return oerr.error
+		}
+		// Sessions ending (expiry included) are what reconnecting is for,
+		// only failing to get one counts
+		if connected {
+			failures = 0
+		} else {
+			failures++
+			if t.MaxFailures > 0 && failures >= t.MaxFailures {
+				return err
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// One session's worth of console output. connected reports whether the session
+// was opened and connected to, err is why it ended.
+func (t *Tailer) follow(stop <-chan struct{}) (connected bool, err error) {
+	s, errs := Open(t.Client, t.InstanceId, TypeSerial)
+	if errs != nil {
+		return false, fmt.Errorf("Unable to open console session for %s: %v", t.InstanceId, errs)
+	}
+	if t.OnSession != nil {
+		t.OnSession(s)
+	}
+	conn, err := Dial(s)
+	if err != nil {
+		return
+	}
+	connected = true
+
+	var once sync.Once
+	done := make(chan struct{})
+	defer once.Do(func() { close(done) })
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+			conn.Close()
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		line, erro := r.ReadString('\n')
+		if len(line) > 0 {
+			// Stamped with the time the line started
+			if t.partial == "" {
+				t.partialAt = t.timestamp()
+			}
+			t.partial += line
+			if line[len(line)-1] == '\n' {
+				if werr := t.flush(); werr != nil {
+					return connected, outputError{werr}
+				}
+			}
+		}
+		if erro != nil {
+			if erro == io.EOF {
+				erro = nil
+			}
+			return connected, erro
+		}
+	}
+}
+
+// Writes out the line so far, ending it if the console didn't
+func (t *Tailer) flush() error {
+	if t.partial == "" {
+		return nil
+	}
+	line := t.partial
+	if line[len(line)-1] != '\n' {
+		line += "\n"
+	}
+	t.partial = ""
+	_, err := fmt.Fprintf(t.Out, "%s %s", t.partialAt, line)
+	return err
+}
+
+// Failing to write the log out is not something reconnecting will fix
+type outputError struct {
+	error
+}
+
+func (t *Tailer) timestamp() string {
+	now := time.Now
+	if t.now != nil {
+		now = t.now
+	}
+	format := t.TimeFormat
+	if format == "" {
+		format = time.RFC3339Nano
+	}
+	return now().Format(format)
+}
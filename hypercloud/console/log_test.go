@@ -0,0 +1,146 @@
+package console
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTailerReconnectsOnExpiry(t *testing.T) {
+	sim := newSimulatedConsole(t, "[    0.000000] Linux version 4.4.0\nbooting", "\nUbuntu 16.04 LTS login:\n")
+	client := &fakeClient{endpoint: sim.listener.Addr().String(), expiresIn: 0.2}
+
+	out := &syncBuffer{}
+	tailer := NewTailer(client, "i-1", out)
+	tailer.ReconnectDelay = 10 * time.Millisecond
+	tailer.now = func() time.Time { return time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC) }
+	tailer.TimeFormat = time.RFC3339
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- tailer.Run(stop) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "login:") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("Tailer returned an error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"2017-03-01T12:00:00Z [    0.000000] Linux version 4.4.0",
+		"2017-03-01T12:00:00Z booting",
+		"2017-03-01T12:00:00Z Ubuntu 16.04 LTS login:",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d lines, got %d:\n%s", len(want), len(lines), out.String())
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("Line %d: expected %q, got %q", i, want[i], lines[i])
+		}
+	}
+	if client.sessions < 2 {
+		t.Errorf("Expected the tailer to open a new session after expiry, opened %d", client.sessions)
+	}
+}
+
+func TestTailerJoinsLinesSplitBySessions(t *testing.T) {
+	sim := newSimulatedConsole(t, "boot", "ing\nready")
+	client := &fakeClient{endpoint: sim.listener.Addr().String(), expiresIn: 0.2}
+
+	out := &syncBuffer{}
+	tailer := NewTailer(client, "i-1", out)
+	tailer.ReconnectDelay = 10 * time.Millisecond
+	var mu sync.Mutex
+	tick := 0
+	tailer.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		tick++
+		return time.Date(2017, 3, 1, 12, 0, tick, 0, time.UTC)
+	}
+	tailer.TimeFormat = time.RFC3339
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- tailer.Run(stop) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for sim.connections() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("Tailer returned an error: %v", err)
+	}
+
+	// The line is stamped when it started, the unfinished one is written out on stop
+	want := "2017-03-01T12:00:01Z booting\n2017-03-01T12:00:02Z ready\n"
+	if out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
+
+func TestTailerKeepsFollowingIdleConsole(t *testing.T) {
+	sim := newSimulatedConsole(t) //never says anything
+	client := &fakeClient{endpoint: sim.listener.Addr().String(), expiresIn: 0.05}
+
+	tailer := NewTailer(client, "i-1", &syncBuffer{})
+	tailer.ReconnectDelay = time.Millisecond
+	tailer.MaxFailures = 2
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- tailer.Run(stop) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for sim.connections() < 4 && time.Now().Before(deadline) {
+		select {
+		case err := <-done:
+			t.Fatalf("Tailer gave up on a quiet console after %d sessions: %v", sim.connections(), err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("Tailer returned an error: %v", err)
+	}
+	if sim.connections() < 4 {
+		t.Errorf("Expected the tailer to keep reconnecting as sessions expired, connected %d times", sim.connections())
+	}
+}
+
+func TestTailerGivesUp(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close() //nothing listening any more
+
+	tailer := NewTailer(&fakeClient{endpoint: addr}, "i-1", &syncBuffer{})
+	tailer.ReconnectDelay = time.Millisecond
+	tailer.MaxFailures = 3
+	if err := tailer.Run(make(chan struct{})); err == nil {
+		t.Errorf("Expected an error once the endpoint stayed unreachable")
+	}
+}
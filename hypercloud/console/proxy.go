@@ -0,0 +1,136 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Listens on a local address and forwards every connection to the instance's
+// console, so standard VNC viewers can be pointed at it. Each local connection
+// gets a fresh console session; when that session expires the local connection
+// is dropped and the viewer can simply reconnect.
+type Proxy struct {
+	Client     Client
+	InstanceId string
+	Type       string
+
+	// Called with every new session before it is connected, handy for showing
+	// the VNC password to whoever is running the viewer.
+	OnSession func(s *Session)
+	// Called when a proxied connection fails, defaults to dropping the error.
+	OnError func(err error)
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	active map[net.Conn]bool //local connections being proxied
+	closed bool
+}
+
+// Listens on addr (e.g. "127.0.0.1:5900", use port 0 to pick one) for VNC viewers
+func NewProxy(c Client, instanceId string, addr string) (p *Proxy, err error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	p = &Proxy{Client: c, InstanceId: instanceId, Type: TypeVNC, listener: l, active: make(map[net.Conn]bool)}
+	return
+}
+
+func (p *Proxy) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+// Accepts connections until the proxy is closed, then waits for the connections
+// being proxied to wind down
+func (p *Proxy) Serve() error {
+	for {
+		local, err := p.listener.Accept()
+		if err != nil {
+			p.wg.Wait()
+			return err
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			if err := p.handle(local); err != nil && p.OnError != nil {
+				p.OnError(err)
+			}
+		}()
+	}
+}
+
+// Stops listening and drops every connection being proxied
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	active := make([]net.Conn, 0, len(p.active))
+	for conn := range p.active {
+		active = append(active, conn)
+	}
+	p.mu.Unlock()
+	err := p.listener.Close()
+	for _, conn := range active {
+		conn.Close()
+	}
+	return err
+}
+
+// Tracks a local connection so Close can drop it, false if already closed
+func (p *Proxy) track(local net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.active[local] = true
+	return true
+}
+
+func (p *Proxy) untrack(local net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, local)
+}
+
+func (p *Proxy) handle(local net.Conn) error {
+	defer local.Close()
+	if !p.track(local) {
+		return nil
+	}
+	defer p.untrack(local)
+	s, errs := Open(p.Client, p.InstanceId, p.Type)
+	if errs != nil {
+		return fmt.Errorf("Unable to open console session for %s: %v", p.InstanceId, errs)
+	}
+	if p.OnSession != nil {
+		p.OnSession(s)
+	}
+	remote, err := Dial(s)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+	return pipe(local, remote)
+}
+
+// Copies both ways until either side gives up, returns the first error worth reporting
+func pipe(local net.Conn, remote *Conn) error {
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(remote, local)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(local, remote)
+		errc <- err
+	}()
+	err := <-errc
+	local.Close()
+	remote.Close()
+	<-errc
+	return err
+}
@@ -0,0 +1,69 @@
+package console
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startProxy(t *testing.T, client *fakeClient, onSession func(s *Session)) (*Proxy, chan error) {
+	p, err := NewProxy(client, "i-1", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.OnSession = onSession
+	served := make(chan error, 1)
+	go func() { served <- p.Serve() }()
+	t.Cleanup(func() { p.Close() })
+	return p, served
+}
+
+func dialProxy(t *testing.T, p *Proxy, greeting string) net.Conn {
+	viewer, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { viewer.Close() })
+	viewer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len(greeting))
+	if _, err := io.ReadFull(viewer, buf); err != nil || string(buf) != greeting {
+		t.Fatalf("Expected %q through the proxy, got %q (%v)", greeting, buf, err)
+	}
+	return viewer
+}
+
+func TestProxy(t *testing.T) {
+	sim := newSimulatedConsole(t, "RFB 003.008\n", "RFB 003.008\n")
+	client := &fakeClient{endpoint: sim.listener.Addr().String(), expiresIn: 0.2}
+	sessions := make(chan *Session, 2)
+	p, _ := startProxy(t, client, func(s *Session) { sessions <- s })
+
+	viewer := dialProxy(t, p, "RFB 003.008\n")
+	// The session expires and the viewer is dropped, it can reconnect for a new one
+	if _, err := viewer.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the viewer to be dropped when the session expired")
+	}
+	dialProxy(t, p, "RFB 003.008\n")
+	first, second := <-sessions, <-sessions
+	if first.Id == second.Id || first.Type != TypeVNC {
+		t.Errorf("Expected a fresh VNC session per connection, got %+v and %+v", first, second)
+	}
+}
+
+func TestProxyCloseDropsConnections(t *testing.T) {
+	sim := newSimulatedConsole(t, "RFB 003.008\n")
+	client := &fakeClient{endpoint: sim.listener.Addr().String(), expiresIn: 600}
+	p, served := startProxy(t, client, nil)
+	viewer := dialProxy(t, p, "RFB 003.008\n")
+
+	p.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve still waiting on the open connection after Close")
+	}
+	if _, err := viewer.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the viewer to be disconnected")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Package console connects to the remote console of a hypercloud instance.
+
+InstanceRemoteAccess hands back a console session describing where the console
+lives (a websocket or plain tcp endpoint) and how to authenticate against it.
+This package turns that description into a live connection, and can expose it
+on a local port so a regular VNC viewer can be pointed at it.
+*/
+package console
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	TypeVNC    = "vnc"
+	TypeSerial = "serial"
+)
+
+// The bits of the hypercloud client this package needs. *hypercloud satisfies it.
+type Client interface {
+	InstanceRemoteAccess(instanceId string, body interface{}) (interface{}, []error)
+	ConsoleSessionInfo(consoleSessionIdentity string) (interface{}, []error)
+}
+
+// A console session as described by the API
+type Session struct {
+	Id         string
+	InstanceId string
+	Type       string
+	URL        string //ws://, wss:// or tcp://
+	Password   string //handed to the VNC viewer, not used for the transport
+	Token      string
+	ExpiresAt  time.Time //zero if the API didn't tell us
+}
+
+// Creates a new console session of the given type (TypeVNC, TypeSerial) for an instance
+func Open(c Client, instanceId string, kind string) (s *Session, err []error) {
+	ret, err := c.InstanceRemoteAccess(instanceId, map[string]interface{}{"type": kind})
+	if err != nil {
+		return
+	}
+	s, erro := parseSession(ret)
+	if erro != nil {
+		err = append(err, erro)
+		return
+	}
+	if s.InstanceId == "" {
+		s.InstanceId = instanceId
+	}
+	if s.Type == "" {
+		s.Type = kind
+	}
+	return
+}
+
+// Fetches the current description of an existing session
+func Info(c Client, sessionId string) (s *Session, err []error) {
+	ret, err := c.ConsoleSessionInfo(sessionId)
+	if err != nil {
+		return
+	}
+	s, erro := parseSession(ret)
+	if erro != nil {
+		err = append(err, erro)
+	}
+	return
+}
+
+func (s *Session) Expired() bool {
+	return !s.ExpiresAt.IsZero() && !time.Now().Before(s.ExpiresAt)
+}
+
+// Time left before the session expires, or -1 if it doesn't expire
+func (s *Session) Remaining() time.Duration {
+	if s.ExpiresAt.IsZero() {
+		return -1
+	}
+	return time.Until(s.ExpiresAt)
+}
+
+func parseSession(data interface{}) (*Session, error) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Unexpected console session response: %v", data)
+	}
+	str := func(keys ...string) string {
+		for _, k := range keys {
+			switch v := m[k].(type) {
+			case string:
+				if v != "" {
+					return v
+				}
+			case float64:
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		return ""
+	}
+	s := &Session{
+		Id:       str("id"),
+		Type:     str("type", "protocol"),
+		URL:      str("websocket_url", "url"),
+		Password: str("password"),
+		Token:    str("token"),
+	}
+	switch v := m["instance"].(type) {
+	case string:
+		s.InstanceId = v
+	case map[string]interface{}:
+		s.InstanceId, _ = v["id"].(string)
+	}
+	if s.URL == "" {
+		host, port := str("host"), str("port")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("Console session %s has no endpoint", s.Id)
+		}
+		s.URL = "tcp://" + host + ":" + port
+	}
+	if _, err := url.Parse(s.URL); err != nil {
+		return nil, fmt.Errorf("Console session %s has an invalid endpoint: %v", s.Id, err)
+	}
+	if exp := str("expires_at", "expiry"); exp != "" {
+		t, err := time.Parse(time.RFC3339, exp)
+		if err != nil {
+			return nil, fmt.Errorf("Console session %s has an invalid expiry: %v", s.Id, err)
+		}
+		s.ExpiresAt = t
+	} else if secs, ok := m["expires_in"].(float64); ok {
+		s.ExpiresAt = time.Now().Add(time.Duration(secs * float64(time.Second)))
+	}
+	return s, nil
+}
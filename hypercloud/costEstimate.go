@@ -0,0 +1,296 @@
+package hypercloud
+
+import (
+	Json "encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const HoursPerMonth = 730
+
+// A resource to be priced, either planned or read back from the account
+type ResourceSpec struct {
+	Kind     string //"instance", "disk" or "ip"
+	Id       string //empty for planned resources
+	Name     string
+	Region   string
+	Tier     string  //performance tier id or name
+	MemoryMB float64 //instances
+	SizeGB   float64 //disks
+	Public   bool    //ips
+	Context  map[string]string
+}
+
+// Prices to use where the API doesn't report any, usually loaded from a JSON file:
+//
+//	{
+//	    "currency": "AUD",
+//	    "instance_gb_hour": {"Standard": 0.02},
+//	    "disk_gb_hour": {"Standard": 0.0002},
+//	    "public_ip_hour": 0.005,
+//	    "private_ip_hour": 0.001
+//	}
+//
+// Tier keys may be tier ids or names (case insensitive), an id wins over a name.
+// A price of 0 counts as no price.
+type PriceTable struct {
+	Currency       string             `json:"currency"`
+	InstanceGBHour map[string]float64 `json:"instance_gb_hour"`
+	DiskGBHour     map[string]float64 `json:"disk_gb_hour"`
+	PublicIPHour   float64            `json:"public_ip_hour"`
+	PrivateIPHour  float64            `json:"private_ip_hour"`
+}
+
+func LoadPriceTable(path string) (table *PriceTable, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	table = &PriceTable{}
+	if err = Json.NewDecoder(f).Decode(table); err != nil {
+		return nil, fmt.Errorf("Invalid price table %s: %v", path, err)
+	}
+	return
+}
+
+func tablePrice(prices map[string]float64, tier PerformanceTier, fallback string) (float64, bool) {
+	if v := prices[tier.Id]; v != 0 && tier.Id != "" {
+		return v, true
+	}
+	name := tier.Name
+	if tier.Id == "" {
+		name = fallback
+	}
+	keys := make([]string, 0, len(prices))
+	for k := range prices {
+		keys = append(keys, k)
+	}
+	// An exact match, then the first in order that differs only in case
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == name {
+			return prices[k], prices[k] != 0
+		}
+	}
+	for _, k := range keys {
+		if strings.EqualFold(k, name) {
+			return prices[k], prices[k] != 0
+		}
+	}
+	return 0, false
+}
+
+// The hourly price of gb (of memory or disk) from a tier's API price, if the
+// API says what unit that price is in
+func apiPrice(tier PerformanceTier, gb float64) (float64, bool) {
+	if tier.Price == 0 {
+		return 0, false
+	}
+	switch priceUnit(tier.PriceUnit) {
+	case "gb_hour":
+		return tier.Price * gb, true
+	case "hour":
+		return tier.Price, true
+	}
+	return 0, false
+}
+
+type CostLine struct {
+	Spec     ResourceSpec
+	Hourly   float64
+	Monthly  float64
+	Currency string //the API's for api prices, the table's otherwise, empty if not known
+	Source   string //"api", "table" or "unpriced"
+}
+
+type CostEstimate struct {
+	Currency      string //shared by every priced line, empty if they don't agree
+	MixedCurrency bool   //priced lines are in different (or unknown) currencies, so the totals don't add up
+	Lines         []CostLine
+	Hourly        float64
+	Monthly       float64
+	ByKind        map[string]float64 //monthly
+	ByTag         map[string]float64 //monthly, keyed "key=value" from the resources' context
+	Unpriced      int
+}
+
+// Prices the specs. Tier prices from the API are used when present and in a
+// unit we understand (per GB of memory or disk per hour, or per hour), otherwise
+// the table. Resources with no price anywhere are listed as unpriced rather
+// than failing the estimate. Each line carries the currency of wherever its
+// price came from, the estimate is flagged when they don't all agree.
+func (h *hypercloud) CostEstimate(specs []ResourceSpec, table *PriceTable) (est CostEstimate, err []error) {
+	instanceTiers, err := h.PerformanceTierListInstance()
+	if err != nil {
+		return
+	}
+	diskTiers, err := h.PerformanceTierListDisk()
+	if err != nil {
+		return
+	}
+	if table == nil {
+		table = &PriceTable{}
+	}
+	est = CostEstimate{Currency: table.Currency, ByKind: make(map[string]float64), ByTag: make(map[string]float64)}
+	priced := 0
+
+	for _, spec := range specs {
+		line := CostLine{Spec: spec, Source: "unpriced"}
+		switch spec.Kind {
+		case "instance":
+			tier := findTier(instanceTiers, spec.Tier, spec.Region)
+			if p, ok := apiPrice(tier, spec.MemoryMB/1024); ok {
+				line.Hourly, line.Currency, line.Source = p, tier.Currency, "api"
+			} else if p, ok := tablePrice(table.InstanceGBHour, tier, spec.Tier); ok {
+				line.Hourly, line.Currency, line.Source = p*spec.MemoryMB/1024, table.Currency, "table"
+			}
+		case "disk":
+			tier := findTier(diskTiers, spec.Tier, spec.Region)
+			if p, ok := apiPrice(tier, spec.SizeGB); ok {
+				line.Hourly, line.Currency, line.Source = p, tier.Currency, "api"
+			} else if p, ok := tablePrice(table.DiskGBHour, tier, spec.Tier); ok {
+				line.Hourly, line.Currency, line.Source = p*spec.SizeGB, table.Currency, "table"
+			}
+		case "ip":
+			p := table.PrivateIPHour
+			if spec.Public {
+				p = table.PublicIPHour
+			}
+			if p != 0 {
+				line.Hourly, line.Currency, line.Source = p, table.Currency, "table"
+			}
+		default:
+			err = append(err, fmt.Errorf("Unknown resource kind %q for %s", spec.Kind, spec.Name))
+			continue
+		}
+		if line.Source == "unpriced" {
+			est.Unpriced++
+		} else if priced++; priced == 1 {
+			est.Currency = line.Currency
+		} else if line.Currency != est.Currency {
+			est.MixedCurrency = true
+		}
+		line.Monthly = line.Hourly * HoursPerMonth
+		est.Lines = append(est.Lines, line)
+		est.Hourly += line.Hourly
+		est.Monthly += line.Monthly
+		est.ByKind[spec.Kind] += line.Monthly
+		for k, v := range spec.Context {
+			est.ByTag[k+"="+v] += line.Monthly
+		}
+	}
+	if est.MixedCurrency {
+		est.Currency = ""
+	}
+	return
+}
+
+// Looks a tier up by id, or by name within the region
+func findTier(list interface{}, tier string, region string) PerformanceTier {
+	for _, t := range sliceOf(list) {
+		parsed := parsePerformanceTier(t)
+		if parsed.Id == tier {
+			return parsed
+		}
+	}
+	for _, t := range sliceOf(list) {
+		parsed := parsePerformanceTier(t)
+		if strings.EqualFold(parsed.Name, tier) && (region == "" || parsed.Region == region || strings.EqualFold(parsed.RegionCode, region)) {
+			return parsed
+		}
+	}
+	return PerformanceTier{Name: tier}
+}
+
+func contextOf(data interface{}) map[string]string {
+	ctx := make(map[string]string)
+	for k, v := range mapOf(mapOf(data)["context"]) {
+		ctx[k] = fmt.Sprintf("%v", v)
+	}
+	return ctx
+}
+
+// Everything on the account as specs, ready for CostEstimate
+func (h *hypercloud) AccountSnapshot() (specs []ResourceSpec, err []error) {
+	instances, err := h.InstanceList()
+	if err != nil {
+		return
+	}
+	for _, i := range sliceOf(instances) {
+		specs = append(specs, ResourceSpec{
+			Kind:     "instance",
+			Id:       idOf(i),
+			Name:     stringOf(i, "name"),
+			Region:   idOf(mapOf(i)["region"]),
+			Tier:     idOf(mapOf(i)["performance_tier"]),
+			MemoryMB: numberOf(i, "memory"),
+			Context:  contextOf(i),
+		})
+	}
+	disks, err := h.DiskList()
+	if err != nil {
+		return
+	}
+	for _, d := range sliceOf(disks) {
+		specs = append(specs, ResourceSpec{
+			Kind:    "disk",
+			Id:      idOf(d),
+			Name:    stringOf(d, "name"),
+			Region:  idOf(mapOf(d)["region"]),
+			Tier:    idOf(mapOf(d)["performance_tier"]),
+			SizeGB:  numberOf(d, "size"),
+			Context: contextOf(d),
+		})
+	}
+	ips, err := h.IPAddressList()
+	if err != nil {
+		return
+	}
+	for _, ip := range sliceOf(ips) {
+		specs = append(specs, ResourceSpec{
+			Kind:   "ip",
+			Id:     idOf(ip),
+			Name:   stringOf(ip, "address"),
+			Region: idOf(mapOf(ip)["region"]),
+			Public: stringOf(ip, "type") == "public",
+		})
+	}
+	return
+}
+
+// Writes the estimate as a table, a line per resource followed by the totals
+func (est CostEstimate) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "KIND\tNAME\tTIER\tHOURLY\tMONTHLY\tCURRENCY\tSOURCE\n")
+	for _, l := range est.Lines {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.4f\t%.2f\t%s\t%s\n", l.Spec.Kind, l.Spec.Name, l.Spec.Tier, l.Hourly, l.Monthly, l.Currency, l.Source)
+	}
+	fmt.Fprintf(tw, "\t\t\t\t\t\t\n")
+	currency := est.Currency
+	if est.MixedCurrency {
+		currency = "mixed"
+	}
+	kinds := make([]string, 0, len(est.ByKind))
+	for k := range est.ByKind {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		fmt.Fprintf(tw, "total %s\t\t\t\t%.2f\t%s\t\n", k, est.ByKind[k], currency)
+	}
+	tags := make([]string, 0, len(est.ByTag))
+	for t := range est.ByTag {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	for _, t := range tags {
+		fmt.Fprintf(tw, "tag %s\t\t\t\t%.2f\t%s\t\n", t, est.ByTag[t], currency)
+	}
+	fmt.Fprintf(tw, "total\t\t\t%.4f\t%.2f\t%s\t\n", est.Hourly, est.Monthly, currency)
+	return tw.Flush()
+}
@@ -0,0 +1,136 @@
+package hypercloud
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCostEstimate(t *testing.T) {
+	cloud := newFakeCloud()
+	cloud.lists["/performance_tiers/instances"] = []interface{}{
+		map[string]interface{}{"id": "t-std", "name": "Standard", "price": 0.02, "price_unit": "GB/hour"},
+		map[string]interface{}{"id": "t-flat", "name": "Flat", "price": 0.5, "price_unit": "hour"},
+		// A price without a unit can't be used, the table has to do
+		map[string]interface{}{"id": "t-odd", "name": "Odd", "price": 3},
+	}
+	cloud.lists["/performance_tiers/disks"] = []interface{}{
+		map[string]interface{}{"id": "d-ssd", "name": "SSD"},
+	}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	table := &PriceTable{
+		Currency:       "AUD",
+		InstanceGBHour: map[string]float64{"ODD": 0.01},
+		DiskGBHour:     map[string]float64{"d-ssd": 0.0002, "SSD": 1, "ssd": 2},
+		PublicIPHour:   0.005,
+	}
+	specs := []ResourceSpec{
+		{Kind: "instance", Name: "web", Tier: "t-std", MemoryMB: 2048, Context: map[string]string{"team": "web"}},
+		{Kind: "instance", Name: "flat", Tier: "Flat", MemoryMB: 4096},
+		{Kind: "instance", Name: "odd", Tier: "t-odd", MemoryMB: 1024},
+		{Kind: "disk", Name: "data", Tier: "d-ssd", SizeGB: 100, Context: map[string]string{"team": "web"}},
+		{Kind: "disk", Name: "old", Tier: "HDD", SizeGB: 100},
+		{Kind: "ip", Name: "203.0.113.5", Public: true},
+		{Kind: "ip", Name: "10.0.0.5"},
+	}
+
+	est, err := hc.CostEstimate(specs, table)
+	if err != nil {
+		t.Fatalf("CostEstimate failed: %v", err)
+	}
+	want := []struct {
+		hourly float64
+		source string
+	}{
+		{0.04, "api"},
+		{0.5, "api"},
+		{0.01, "table"},
+		{0.02, "table"}, //by id, over the names
+		{0, "unpriced"},
+		{0.005, "table"},
+		{0, "unpriced"}, //no private IP price
+	}
+	for i, w := range want {
+		l := est.Lines[i]
+		if math.Abs(l.Hourly-w.hourly) > 1e-9 || l.Source != w.source {
+			t.Errorf("%s: expected %v from %s, got %v from %s", l.Spec.Name, w.hourly, w.source, l.Hourly, l.Source)
+		}
+	}
+	if est.Unpriced != 2 {
+		t.Errorf("Expected 2 unpriced resources, got %d", est.Unpriced)
+	}
+	if math.Abs(est.ByTag["team=web"]-0.06*HoursPerMonth) > 1e-9 {
+		t.Errorf("Unexpected team=web total %v", est.ByTag["team=web"])
+	}
+
+	est, _ = hc.CostEstimate([]ResourceSpec{{Kind: "ip", Name: "203.0.113.5", Public: true}}, nil)
+	if est.Lines[0].Source != "unpriced" || est.Unpriced != 1 {
+		t.Errorf("Expected an IP to be unpriced without a table, got %+v", est.Lines[0])
+	}
+}
+
+func TestTablePrice(t *testing.T) {
+	prices := map[string]float64{"Standard": 1, "STANDARD": 2, "standard": 3, "t-1": 4, "Free": 0, "t-5": 0}
+	tests := []struct {
+		tier     PerformanceTier
+		fallback string
+		price    float64
+		ok       bool
+	}{
+		{PerformanceTier{Id: "t-1", Name: "Standard"}, "", 4, true},
+		{PerformanceTier{Id: "t-2", Name: "standard"}, "", 3, true},
+		{PerformanceTier{Id: "t-2", Name: "Standard"}, "", 1, true},
+		{PerformanceTier{Id: "t-2", Name: "StAnDaRd"}, "", 2, true},
+		{PerformanceTier{Name: "standard"}, "standard", 3, true},
+		{PerformanceTier{Id: "t-3", Name: "Free"}, "", 0, false},
+		{PerformanceTier{Id: "t-4", Name: "Premium"}, "", 0, false},
+		{PerformanceTier{Id: "t-5", Name: "Standard"}, "", 1, true}, //a 0 id entry falls through to the name
+	}
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ { //map order must not matter
+			if price, ok := tablePrice(prices, tt.tier, tt.fallback); price != tt.price || ok != tt.ok {
+				t.Errorf("%+v: expected %v %v, got %v %v", tt.tier, tt.price, tt.ok, price, ok)
+				break
+			}
+		}
+	}
+}
+
+func TestCostEstimateCurrency(t *testing.T) {
+	cloud := newFakeCloud()
+	cloud.lists["/performance_tiers/instances"] = []interface{}{
+		map[string]interface{}{"id": "t-aud", "name": "Aud", "price": 0.5, "price_unit": "hour", "currency": "AUD"},
+		map[string]interface{}{"id": "t-usd", "name": "Usd", "price": 0.5, "price_unit": "hour", "currency": "USD"},
+		map[string]interface{}{"id": "t-none", "name": "None"},
+	}
+	cloud.lists["/performance_tiers/disks"] = []interface{}{}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	table := &PriceTable{Currency: "AUD", InstanceGBHour: map[string]float64{"t-none": 0.01}}
+
+	tests := []struct {
+		tiers    []string
+		currency string
+		mixed    bool
+	}{
+		{[]string{"t-aud", "t-none"}, "AUD", false},
+		{[]string{"t-usd"}, "USD", false},
+		{[]string{"t-usd", "t-none"}, "", true},
+		{[]string{"t-aud", "t-usd"}, "", true},
+	}
+	for _, tt := range tests {
+		var specs []ResourceSpec
+		for _, tier := range tt.tiers {
+			specs = append(specs, ResourceSpec{Kind: "instance", Name: tier, Tier: tier, MemoryMB: 1024})
+		}
+		est, err := hc.CostEstimate(specs, table)
+		if err != nil || est.Currency != tt.currency || est.MixedCurrency != tt.mixed {
+			t.Errorf("%v: expected %q (mixed %v), got %q (mixed %v) %v", tt.tiers, tt.currency, tt.mixed, est.Currency, est.MixedCurrency, err)
+		}
+		var buf bytes.Buffer
+		est.WriteTable(&buf)
+		if total := strings.Fields(buf.String()[strings.LastIndex(buf.String(), "\ntotal"):]); tt.mixed != (total[len(total)-1] == "mixed") {
+			t.Errorf("%v: unexpected total line %v", tt.tiers, total)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package hypercloud
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	DiskStateAttached   = "attached"
+	DiskStateUnattached = "unattached"
+)
+
+type DiskCloneOptions struct {
+	Name            string            //defaults to "<source name>-clone"
+	PerformanceTier string            //defaults to the source's tier
+	Region          string            //defaults to the source's region
+	Context         map[string]string //set on the copy, e.g. CopyContext
+	Timeout         time.Duration
+
+	// Called on every poll while the clone is being made. Percent is -1 when
+	// the API doesn't report progress.
+	Progress func(diskId string, state string, percent float64)
+}
+
+// The current state of a disk, and the progress of whatever it is doing if the API says
+func (h *hypercloud) DiskCurrentState(diskId string) (state string, percent float64, err []error) {
+	ret, err := h.DiskState(diskId, nil)
+	if err != nil {
+		return
+	}
+	percent = -1
+	if s, ok := ret.(string); ok {
+		state = s
+		return
+	}
+	state = stringOf(ret, "state")
+	if m := mapOf(ret); m != nil {
+		if _, ok := m["progress"]; ok {
+			percent = numberOf(ret, "progress")
+		}
+	}
+	if state == "" {
+		err = append(err, fmt.Errorf("Unable to determine state of disk %s", diskId))
+	}
+	return
+}
+
+// Clones a disk and waits for the copy to become usable. Only disks which are
+// settled (attached or unattached) can be cloned.
+func (h *hypercloud) DiskCloneAndWait(diskId string, opts DiskCloneOptions) (clone interface{}, err []error) {
+	clone, err = h.diskCloneStart(diskId, opts)
+	if err != nil {
+		return
+	}
+	if _, err = h.diskWaitSettled(idOf(clone), opts.Timeout, opts.Progress); err != nil {
+		return
+	}
+	clone, err = h.DiskInfo(idOf(clone))
+	return
+}
+
+// Validates the source and fires off the clone without waiting for it
+func (h *hypercloud) diskCloneStart(diskId string, opts DiskCloneOptions) (clone interface{}, err []error) {
+	source, err := h.DiskInfo(diskId)
+	if err != nil {
+		return
+	}
+	if state := stringOf(source, "state"); state != DiskStateAttached && state != DiskStateUnattached {
+		err = append(err, fmt.Errorf("Disk %s can't be cloned while %s", diskId, state))
+		return
+	}
+
+	body := make(map[string]interface{})
+	body["name"] = opts.Name
+	if opts.Name == "" {
+		body["name"] = stringOf(source, "name") + "-clone"
+	}
+	body["performance_tier"] = opts.PerformanceTier
+	if opts.PerformanceTier == "" {
+		body["performance_tier"] = idOf(mapOf(source)["performance_tier"])
+	}
+	body["region"] = opts.Region
+	if opts.Region == "" {
+		body["region"] = idOf(mapOf(source)["region"])
+	}
+
+	if len(opts.Context) > 0 {
+		body["context"] = opts.Context
+	}
+
+	clone, err = h.DiskClone(diskId, body)
+	if err == nil && idOf(clone) == "" {
+		err = append(err, fmt.Errorf("Clone of disk %s returned no id", diskId))
+	}
+	return
+}
+
+// Waits for a disk to finish whatever it is doing, i.e. become attached or unattached
+func (h *hypercloud) diskWaitSettled(diskId string, timeout time.Duration, progress func(string, string, float64)) (state string, err []error) {
+	h, done := h.workflow("DiskWaitSettled", map[string]string{"disk_id": diskId})
+	defer func() { done(err) }()
+	if timeout == 0 {
+		timeout = 30 * time.Minute
+	}
+	end := time.Now().Add(timeout)
+	for {
+		var percent float64
+		state, percent, err = h.DiskCurrentState(diskId)
+		if err != nil {
+			return
+		}
+		if progress != nil {
+			progress(diskId, state, percent)
+		}
+		if state == DiskStateUnattached || state == DiskStateAttached {
+			return
+		}
+		if !end.After(time.Now()) {
+			err = append(err, fmt.Errorf("Timed out waiting for disk %s (currently %s)", diskId, state))
+			return
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Snapshots are plain clones named "<source name>-snap-<source hash>-<UTC timestamp>[-<label>]"
+// (the source hash being a short hash of the source disk's id), with context
+// keys saying what they are a copy of. Disks are renamed and names needn't be
+// unique, so it's the context that ties a snapshot to its source. The name
+// only stands in for copies made where the API keeps no context for disks.
+
+const (
+	CopyKindContextKey   = "hypercloud_copy"    //"snapshot" or "backup"
+	CopySourceContextKey = "hypercloud_copy_of" //id of the source disk
+)
+
+// The context marking a disk as a copy of sourceId
+func CopyContext(kind string, sourceId string) map[string]string {
+	return map[string]string{CopyKindContextKey: kind, CopySourceContextKey: sourceId}
+}
+
+// What a disk is a copy of according to its context, empty if it isn't marked.
+// hasContext reports whether the disk carries a context at all.
+func copyMarker(disk interface{}) (kind string, sourceId string, hasContext bool) {
+	ctx := mapOf(mapOf(disk)["context"])
+	return stringOf(ctx, CopyKindContextKey), stringOf(ctx, CopySourceContextKey), ctx != nil
+}
+
+const snapshotMarker = "-snap-"
+const snapshotTimeFormat = "20060102t150405"
+
+// Anchored on the hash and timestamp, so neither the source name nor the label
+// can throw it off by containing "-snap-"
+var snapshotNamePattern = regexp.MustCompile(`^(.+?)-snap-([0-9a-f]{8})-([0-9]{8}t[0-9]{6})(?:-(.*))?$`)
+
+// Overridden by tests, snapshot names only have a resolution of a second
+var snapshotClock = time.Now
+
+type DiskSnapshot struct {
+	Id         string
+	Name       string
+	SourceName string //when the snapshot was taken
+	SourceHash string
+	Label      string
+	Taken      time.Time
+}
+
+func snapshotName(sourceName string, sourceId string, label string, at time.Time) string {
+	name := sourceName + snapshotMarker + shortHash(sourceId) + "-" + at.UTC().Format(snapshotTimeFormat)
+	if label != "" {
+		name += "-" + label
+	}
+	return name
+}
+
+func parseSnapshotName(name string) (snap DiskSnapshot, ok bool) {
+	m := snapshotNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return
+	}
+	taken, err := time.Parse(snapshotTimeFormat, m[3])
+	if err != nil {
+		return
+	}
+	return DiskSnapshot{Name: name, SourceName: m[1], SourceHash: m[2], Label: m[4], Taken: taken}, true
+}
+
+// Takes a point in time copy of a disk. With retention > 0 the oldest snapshots
+// beyond that count are deleted once the new one is ready.
+func (h *hypercloud) DiskSnapshotCreate(diskId string, label string, retention int, opts DiskCloneOptions) (snap DiskSnapshot, err []error) {
+	source, err := h.DiskInfo(diskId)
+	if err != nil {
+		return
+	}
+	opts.Name = snapshotName(stringOf(source, "name"), diskId, label, snapshotClock())
+	opts.Context = CopyContext("snapshot", diskId)
+	clone, err := h.DiskCloneAndWait(diskId, opts)
+	if err != nil {
+		return
+	}
+	snap, _ = parseSnapshotName(opts.Name)
+	snap.Id = idOf(clone)
+	if retention > 0 {
+		_, err = h.DiskSnapshotPrune(diskId, retention)
+	}
+	return
+}
+
+// Snapshots of a disk, newest first
+func (h *hypercloud) DiskSnapshotList(diskId string) (snaps []DiskSnapshot, err []error) {
+	disks, err := h.DiskList()
+	if err != nil {
+		return
+	}
+	for _, d := range sliceOf(disks) {
+		snap, ok := parseSnapshotName(stringOf(d, "name"))
+		if !ok || idOf(d) == diskId {
+			continue
+		}
+		if kind, sourceId, hasContext := copyMarker(d); hasContext {
+			if kind != "snapshot" || sourceId != diskId {
+				continue
+			}
+		} else if snap.SourceHash != shortHash(diskId) {
+			continue
+		}
+		snap.Id = idOf(d)
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Taken.After(snaps[j].Taken) })
+	return
+}
+
+// Deletes all but the newest keep snapshots of a disk, returns what was deleted
+func (h *hypercloud) DiskSnapshotPrune(diskId string, keep int) (deleted []DiskSnapshot, err []error) {
+	snaps, err := h.DiskSnapshotList(diskId)
+	if err != nil || len(snaps) <= keep {
+		return
+	}
+	for _, snap := range snaps[keep:] {
+		if _, erro := h.DiskDelete(snap.Id); erro != nil {
+			err = append(err, erro...)
+			continue
+		}
+		deleted = append(deleted, snap)
+	}
+	return
+}
+
+// Rolls an instance back to a snapshot by putting the snapshot disk in place of
+// diskId, keeping its position in the instance's disk list. The instance is
+// stopped for the swap and started again if it was running.
+func (h *hypercloud) DiskSnapshotRestore(instanceId string, diskId string, snapshotId string, timeout time.Duration) (err []error) {
+	_, err = h.InstanceDiskReplace(instanceId, diskId, snapshotId, timeout)
+	return
+}
@@ -0,0 +1,129 @@
+package hypercloud
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSnapshotName(t *testing.T) {
+	taken := time.Date(2017, 3, 1, 12, 30, 5, 0, time.UTC)
+	cases := []struct {
+		name  string
+		ok    bool
+		label string
+	}{
+		{snapshotName("db-data", "disk-1", "", taken), true, ""},
+		{snapshotName("db-data", "disk-1", "pre-upgrade", taken), true, "pre-upgrade"},
+		{snapshotName("db-snap-data", "disk-1", "", taken), true, ""},
+		{snapshotName("db-data", "disk-1", "pre-snap-"+shortHash("disk-2")+"-20170301t123005", taken), true, "pre-snap-" + shortHash("disk-2") + "-20170301t123005"},
+		{"db-data-snap-20170301t123005", false, ""},
+		{"db-data-snap-zzzzzzzz-20170301t123005", false, ""},
+		{"db-data-snap-" + shortHash("disk-1") + "-2017", false, ""},
+		{"db-data-snap-" + shortHash("disk-1") + "-20170301t123005x", false, ""},
+		{"db-data", false, ""},
+	}
+	for _, c := range cases {
+		snap, ok := parseSnapshotName(c.name)
+		if ok != c.ok {
+			t.Errorf("%s: expected ok=%v", c.name, c.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if snap.SourceHash != shortHash("disk-1") || !snap.Taken.Equal(taken) || snap.Label != c.label {
+			t.Errorf("%s: unexpected %+v", c.name, snap)
+		}
+	}
+	if snap, _ := parseSnapshotName(snapshotName("db-snap-data", "disk-1", "", taken)); snap.SourceName != "db-snap-data" {
+		t.Errorf("Expected the source name to survive a marker inside it, got %s", snap.SourceName)
+	}
+}
+
+func TestDiskSnapshotRetention(t *testing.T) {
+	pollInterval = time.Millisecond
+	clock := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	snapshotClock = func() time.Time { return clock }
+	defer func() { snapshotClock = time.Now }()
+
+	cloud := newFakeCloud()
+	disk := cloud.addDisk("data")
+	other := cloud.addDisk("data")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	otherSnap, err := hc.DiskSnapshotCreate(other, "", 0, DiskCloneOptions{})
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	var taken []DiskSnapshot
+	for i := 0; i < 3; i++ {
+		clock = clock.Add(time.Hour)
+		snap, err := hc.DiskSnapshotCreate(disk, "nightly", 2, DiskCloneOptions{})
+		if err != nil {
+			t.Fatalf("Snapshot %d failed: %v", i, err)
+		}
+		taken = append(taken, snap)
+	}
+
+	snaps, err := hc.DiskSnapshotList(disk)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(snaps) != 2 || snaps[0].Id != taken[2].Id || snaps[1].Id != taken[1].Id {
+		t.Errorf("Expected the newest 2 snapshots newest first, got %+v", snaps)
+	}
+	if _, ok := cloud.disks[taken[0].Id]; ok {
+		t.Errorf("Expected the oldest snapshot to be pruned")
+	}
+	if _, ok := cloud.disks[otherSnap.Id]; !ok {
+		t.Errorf("Snapshot of another disk with the same name was pruned")
+	}
+	if snaps, _ := hc.DiskSnapshotList(other); len(snaps) != 1 || snaps[0].Id != otherSnap.Id {
+		t.Errorf("Expected the other disk's snapshot to be listed on its own, got %+v", snaps)
+	}
+}
+
+func TestDiskSnapshotListAfterRename(t *testing.T) {
+	pollInterval = time.Millisecond
+	clock := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	snapshotClock = func() time.Time { return clock }
+	defer func() { snapshotClock = time.Now }()
+
+	cloud := newFakeCloud()
+	disk := cloud.addDisk("data")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	first, err := hc.DiskSnapshotCreate(disk, "", 0, DiskCloneOptions{})
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if kind, source, _ := copyMarker(cloud.disks[first.Id]); kind != "snapshot" || source != disk {
+		t.Errorf("Expected the snapshot to be marked as a copy of %s, got %v", disk, cloud.disks[first.Id]["context"])
+	}
+	// Made where disks have no context, only the name ties it to the disk
+	legacy := cloud.addDisk(snapshotName("data", disk, "", clock.Add(-time.Hour)))
+	// Named like one of ours but marked as a copy of something else
+	cloud.addDisk(snapshotName("data", disk, "", clock.Add(-2*time.Hour)))
+	for id, d := range cloud.disks {
+		if id != first.Id && id != legacy && id != disk {
+			d["context"] = CopyContext("snapshot", "disk-elsewhere")
+		}
+	}
+
+	cloud.disks[disk]["name"] = "renamed"
+	clock = clock.Add(time.Hour)
+	second, err := hc.DiskSnapshotCreate(disk, "", 2, DiskCloneOptions{})
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	snaps, _ := hc.DiskSnapshotList(disk)
+	if len(snaps) != 2 || snaps[0].Id != second.Id || snaps[1].Id != first.Id {
+		t.Errorf("Expected the snapshots from before and after the rename, got %+v", snaps)
+	}
+	if _, ok := cloud.disks[legacy]; ok {
+		t.Errorf("Expected the oldest snapshot to be pruned")
+	}
+	if len(cloud.disks) != 4 {
+		t.Errorf("Expected the other disk's snapshot to be left alone, got %v", cloud.disks)
+	}
+}
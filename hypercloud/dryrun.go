@@ -0,0 +1,128 @@
+package hypercloud
+
+import (
+	Json "encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Dry run mode: GETs go through as normal, but POST/PUT/DELETE are written to
+// the log with their payload and answered with a made up response instead of
+// being sent. Policies are still evaluated, so a dry run also shows what would
+// be blocked. Helpers which wait on state changes (the power helpers) will time
+// out, since nothing actually changes; InstanceSetDisks knows not to wait.
+
+// A call that would have been made
+type DryRunCall struct {
+	Method string
+	Path   string
+	Body   interface{}
+}
+
+func (c DryRunCall) String() string {
+	if c.Body == nil {
+		return c.Method + " " + c.Path
+	}
+	data, _ := Json.Marshal(c.Body)
+	return c.Method + " " + c.Path + " " + string(data)
+}
+
+type dryRunState struct {
+	mu    sync.Mutex
+	out   io.Writer
+	calls []DryRunCall
+	next  int
+}
+
+// Turns dry run mode on, logging every call that would have been made to out
+// (which may be nil to only record them). Like the other setters this must not
+// be called while requests are in flight, use Plan for a dry run alongside them.
+func (h *hypercloud) SetDryRun(out io.Writer) {
+	h.dryRun = &dryRunState{out: out}
+}
+
+// Back to making real calls
+func (h *hypercloud) ClearDryRun() {
+	h.dryRun = nil
+}
+
+func (h *hypercloud) IsDryRun() bool {
+	return h.dryRun != nil
+}
+
+// The mutating calls recorded since dry run mode was turned on, in order
+func (h *hypercloud) DryRunCalls() []DryRunCall {
+	if h.dryRun == nil {
+		return nil
+	}
+	h.dryRun.mu.Lock()
+	defer h.dryRun.mu.Unlock()
+	return append([]DryRunCall(nil), h.dryRun.calls...)
+}
+
+// A copy of the client in dry run mode, for seeing the calls a change would make
+// while the client itself carries on making real ones, e.g.
+//
+//	plan := hc.Plan()
+//	plan.InstanceUpdate(id, changes)
+//	calls := plan.DryRunCalls()
+//
+// Unlike SetDryRun this leaves the client alone, so it is safe while other
+// goroutines are using it.
+func (h *hypercloud) Plan() *hypercloud {
+	plan := *h
+	plan.dryRun = &dryRunState{}
+	if h.dryRun != nil {
+		plan.dryRun.out = h.dryRun.out
+	}
+	return &plan
+}
+
+// Records the call and makes up a plausible response: creates and clones echo
+// the body back with a placeholder id, updates return the current resource with
+// the changes applied, everything else an empty object.
+func (h *hypercloud) dryRunRequest(method string, url string, data interface{}) (json interface{}) {
+	var body interface{}
+	if data != nil {
+		// Copy it now, callers like InstanceUpdate go on to modify their maps
+		if encoded, err := Json.Marshal(data); err == nil {
+			Json.Unmarshal(encoded, &body)
+		} else {
+			body = data
+		}
+	}
+	call := DryRunCall{method, url, body}
+
+	s := h.dryRun
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	s.next++
+	placeholder := fmt.Sprintf("dry-run-%d", s.next)
+	if s.out != nil {
+		fmt.Fprintf(s.out, "DRY RUN: %s\n", call)
+	}
+	s.mu.Unlock()
+
+	resourceType, resourceId := parseResourcePath(url)
+	parts := strings.Split(strings.Trim(url, "/"), "/")
+	response := make(map[string]interface{})
+	switch {
+	case method == "POST" && (resourceId == "" || parts[len(parts)-1] == "clone"):
+		for k, v := range mapOf(body) {
+			response[k] = v
+		}
+		response["id"] = placeholder
+	case method == "PUT" && len(parts) == 2:
+		if current, _, status, _ := h._request("GET", "/"+resourceType+"/"+resourceId, nil); 200 <= status && status < 300 {
+			for k, v := range mapOf(current) {
+				response[k] = v
+			}
+		}
+		for k, v := range mapOf(body) {
+			response[k] = v
+		}
+	}
+	return response
+}
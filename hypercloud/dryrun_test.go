@@ -0,0 +1,92 @@
+package hypercloud
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDryRunInstanceUpdate(t *testing.T) {
+	cloud := newFakeCloud()
+	boot := cloud.addDisk("boot")
+	inst := cloud.addInstance("web", InstanceStateStopped, boot)
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	log := &bytes.Buffer{}
+	hc.SetDryRun(log)
+	ret, err := hc.InstanceUpdate(inst, map[string]interface{}{
+		"name":        "web-renamed",
+		"disks":       []string{boot},
+		"public_keys": []string{"key-1"},
+	})
+	if err != nil {
+		t.Fatalf("Dry run update failed: %v", err)
+	}
+	if name := stringOf(ret, "name"); name != "web-renamed" {
+		t.Errorf("Expected the synthesized response to carry the new name, got %q", name)
+	}
+
+	calls := hc.DryRunCalls()
+	want := []string{
+		"PUT /instances/" + inst + "/disks",
+		"PUT /instances/" + inst + "/public_keys",
+		"PUT /instances/" + inst,
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("Expected %d calls, got %v", len(want), calls)
+	}
+	for i := range want {
+		if calls[i].Method+" "+calls[i].Path != want[i] {
+			t.Errorf("Call %d: expected %s, got %s", i, want[i], calls[i])
+		}
+	}
+	if !strings.Contains(log.String(), `DRY RUN: PUT /instances/`+inst+` {"name":"web-renamed"}`) {
+		t.Errorf("Expected the payload in the log, got:\n%s", log)
+	}
+	for _, call := range cloud.calls {
+		if !strings.HasPrefix(call, "GET ") {
+			t.Errorf("Mutating call %s reached the API during a dry run", call)
+		}
+	}
+	if cloud.instances[inst]["name"] != "web" {
+		t.Errorf("Instance was changed during a dry run")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	cloud := newFakeCloud()
+	disk := cloud.addDisk("data")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	plan := hc.Plan()
+	plan.DiskDelete(disk)
+	if calls := plan.DryRunCalls(); len(calls) != 1 || calls[0].String() != "DELETE /disks/"+disk {
+		t.Errorf("Unexpected plan: %v", calls)
+	}
+	if hc.IsDryRun() {
+		t.Errorf("Expected the client itself to stay out of dry run mode")
+	}
+	if _, ok := cloud.disks[disk]; !ok {
+		t.Errorf("Disk was deleted while planning")
+	}
+}
+
+func TestPlanAlongsideRequests(t *testing.T) {
+	cloud := newFakeCloud()
+	keep := cloud.addDisk("keep")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			hc.DiskInfo(keep)
+		}
+	}()
+	plan := hc.Plan()
+	plan.DiskDelete(keep)
+	<-done
+	if _, ok := cloud.disks[keep]; !ok {
+		t.Errorf("Disk was deleted while planning")
+	}
+}
@@ -0,0 +1,277 @@
+package hypercloud
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Moving a floating public IP between an active/passive pair of instances.
+
+type FailoverResult struct {
+	IPAddrID   string
+	From       string
+	To         string
+	Detached   bool //the IP was removed from From (false if it wasn't attached there)
+	Attached   bool
+	RolledBack bool //attaching to To failed and the IP was put back on From
+	Took       time.Duration
+}
+
+// Which instance an IP is currently attached to, "" if none. If the IP info
+// doesn't say, the candidate instances' adapters are checked instead.
+func (h *hypercloud) IPAddressInstance(IPAddrID string, candidates ...string) (instanceId string, err []error) {
+	ip, err := h.IPAddressInfo(IPAddrID)
+	if err != nil {
+		return
+	}
+	if instanceId = idOf(mapOf(ip)["instance"]); instanceId != "" {
+		return
+	}
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		adapters, erro := h.InstanceNetworkAdapters(c)
+		if erro != nil {
+			err = append(err, erro...)
+			continue
+		}
+		for _, a := range adapters {
+			if indexOf(a.IPAddresses, IPAddrID) >= 0 {
+				return c, nil
+			}
+		}
+	}
+	return
+}
+
+// Moves an IP from one instance to another. The failed instance may well be
+// unreachable, so if detaching from it fails the attach is still attempted. If
+// attaching fails the IP is put back where it was. The result is verified by
+// reading the adapters back: the standby must have the IP and the old instance
+// must not, so a failed detach is only forgiven if it didn't leave the IP on both.
+// An IP held by some other instance is left alone.
+func (h *hypercloud) IPAddressFailover(IPAddrID string, fromInstanceId string, toInstanceId string) (res FailoverResult, err []error) {
+	start := time.Now()
+	res = FailoverResult{IPAddrID: IPAddrID, From: fromInstanceId, To: toInstanceId}
+	defer func() { res.Took = time.Since(start) }()
+
+	current, err := h.IPAddressInstance(IPAddrID, fromInstanceId, toInstanceId)
+	if err != nil {
+		return
+	}
+	if current == toInstanceId {
+		res.Attached = true
+		return
+	}
+	if current != "" && current != fromInstanceId {
+		err = append(err, fmt.Errorf("IP address %s is attached to %s, not %s", IPAddrID, current, fromInstanceId))
+		return
+	}
+	var detachErr []error
+	if current == fromInstanceId {
+		if _, detachErr = h.InstanceDetachIP(fromInstanceId, IPAddrID); detachErr == nil {
+			res.Detached = true
+		}
+	}
+
+	if _, erro := h.InstanceAttachIP(toInstanceId, IPAddrID); erro != nil {
+		err = append(err, detachErr...)
+		err = append(err, erro...)
+		if res.Detached {
+			if _, erro := h.InstanceAttachIP(fromInstanceId, IPAddrID); erro != nil {
+				err = append(err, fmt.Errorf("Unable to put IP address %s back on %s: %v", IPAddrID, fromInstanceId, erro))
+			} else {
+				res.RolledBack = true
+			}
+		}
+		return
+	}
+
+	now, erro := h.IPAddressInstance(IPAddrID, toInstanceId)
+	if erro != nil {
+		err = append(err, erro...)
+		return
+	}
+	if now != toInstanceId {
+		err = append(err, fmt.Errorf("IP address %s is on %q after failover, expected %s", IPAddrID, now, toInstanceId))
+		return
+	}
+	res.Attached = true
+	if detachErr != nil {
+		// A failed detach from a dead instance doesn't matter as long as it
+		// doesn't still list the IP
+		adapters, erro := h.InstanceNetworkAdapters(fromInstanceId)
+		if erro != nil {
+			err = append(append(err, detachErr...), erro...)
+			return
+		}
+		for _, a := range adapters {
+			if indexOf(a.IPAddresses, IPAddrID) >= 0 {
+				err = append(append(err, detachErr...), fmt.Errorf("IP address %s is attached to both %s and %s", IPAddrID, fromInstanceId, toInstanceId))
+				return
+			}
+		}
+	}
+	return
+}
+
+// Returns an error if the instance should not be serving traffic
+type HealthCheck func(instanceId string) error
+
+// The simplest health check: the instance is running as far as the API knows
+func (h *hypercloud) InstanceRunningCheck(instanceId string) error {
+	state, err := h.InstanceCurrentState(instanceId)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if state != InstanceStateRunning {
+		return fmt.Errorf("Instance %s is %s", instanceId, state)
+	}
+	return nil
+}
+
+// Watches the instance holding a floating IP and moves the IP to its partner
+// once it has failed FailureThreshold checks in a row, provided the partner
+// passes its own check.
+type FailoverController struct {
+	hc       *hypercloud
+	IPAddrID string
+	Pair     [2]string
+	Check    HealthCheck
+
+	Interval         time.Duration //between checks, defaults to 10 seconds
+	FailureThreshold int           //consecutive failures before failing over, defaults to 3
+
+	// Called after every failover attempt
+	OnFailover func(res FailoverResult, err []error)
+	// Called with every failed health check or API error
+	OnError func(err error)
+
+	step     sync.Mutex //held for a whole Step
+	mu       sync.Mutex //guards active and failures
+	active   string
+	failures int
+}
+
+func (h *hypercloud) NewFailoverController(IPAddrID string, primary string, standby string, check HealthCheck) *FailoverController {
+	if check == nil {
+		check = h.InstanceRunningCheck
+	}
+	return &FailoverController{hc: h, IPAddrID: IPAddrID, Pair: [2]string{primary, standby}, Check: check, Interval: 10 * time.Second, FailureThreshold: 3}
+}
+
+// The instance currently holding the IP, as last seen by the controller
+func (c *FailoverController) Active() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+func (c *FailoverController) partner(instanceId string) string {
+	if instanceId == c.Pair[0] {
+		return c.Pair[1]
+	}
+	return c.Pair[0]
+}
+
+// Checks until stop is closed
+func (c *FailoverController) Run(stop <-chan struct{}) {
+	for {
+		c.Step()
+		select {
+		case <-stop:
+			return
+		case <-time.After(c.Interval):
+		}
+	}
+}
+
+// One round of checking, failing over if needed. Exposed so callers can drive
+// it themselves. Rounds don't overlap, but the controller's state is only
+// locked to read and update it, so Active works from health checks and
+// callbacks while a round is going.
+func (c *FailoverController) Step() {
+	c.step.Lock()
+	defer c.step.Unlock()
+	c.mu.Lock()
+	active, failures := c.active, c.failures
+	c.mu.Unlock()
+
+	if active == "" {
+		holder, err := c.hc.IPAddressInstance(c.IPAddrID, c.Pair[0], c.Pair[1])
+		if err != nil {
+			c.report(fmt.Errorf("Unable to find which instance holds %s: %v", c.IPAddrID, err))
+			return
+		}
+		if holder == "" {
+			// Floating IP isn't on either instance, give it to the primary
+			res, err := c.hc.IPAddressFailover(c.IPAddrID, "", c.Pair[0])
+			if err == nil {
+				c.set(c.Pair[0], 0)
+			}
+			if c.OnFailover != nil {
+				c.OnFailover(res, err)
+			}
+			if err != nil {
+				return
+			}
+			holder = c.Pair[0]
+		}
+		active = holder
+		c.set(active, failures)
+	}
+
+	err := c.Check(active)
+	if err == nil {
+		c.set(active, 0)
+		return
+	}
+	failures++
+	c.set(active, failures)
+	c.report(err)
+	threshold := c.FailureThreshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if failures < threshold {
+		return
+	}
+
+	standby := c.partner(active)
+	if err := c.Check(standby); err != nil {
+		c.report(fmt.Errorf("Not failing over to %s, it is unhealthy too: %v", standby, err))
+		return
+	}
+	res, errs := c.hc.IPAddressFailover(c.IPAddrID, active, standby)
+	if errs == nil {
+		c.set(standby, 0)
+	} else {
+		// The IP may have moved part way (or be on both), so go by where the
+		// API says it is rather than where it was
+		holder, err := c.hc.IPAddressInstance(c.IPAddrID, active, standby)
+		if err != nil {
+			c.report(fmt.Errorf("Unable to find which instance holds %s after a failed failover: %v", c.IPAddrID, err))
+			holder = "" //look it up again next round
+		}
+		if holder != active {
+			c.set(holder, 0)
+		}
+	}
+	if c.OnFailover != nil {
+		c.OnFailover(res, errs)
+	}
+}
+
+func (c *FailoverController) set(active string, failures int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active, c.failures = active, failures
+}
+
+func (c *FailoverController) report(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
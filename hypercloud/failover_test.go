@@ -0,0 +1,136 @@
+package hypercloud
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newFailoverCloud(t *testing.T) (cloud *fakeCloud, hc hypercloud, ip string, a string, b string) {
+	cloud = newFakeCloud()
+	ip = cloud.addIP("203.0.113.5", "net-public")
+	a = cloud.addInstance("a", InstanceStateRunning)
+	b = cloud.addInstance("b", InstanceStateRunning)
+	hc = newTestHypercloud(t, cloud.ServeHTTP)
+	if _, err := hc.InstanceAttachIP(a, ip); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	return
+}
+
+func TestIPAddressFailover(t *testing.T) {
+	cloud, hc, ip, a, b := newFailoverCloud(t)
+
+	res, err := hc.IPAddressFailover(ip, a, b)
+	if err != nil {
+		t.Fatalf("Failover failed: %v", err)
+	}
+	if !res.Detached || !res.Attached || res.RolledBack {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if got := cloud.adapters(a); !reflect.DeepEqual(got, [][]string{{"net-public"}}) {
+		t.Errorf("Expected the IP to have left %s, got %v", a, got)
+	}
+	if got := cloud.adapters(b); !reflect.DeepEqual(got, [][]string{{"net-public", ip}}) {
+		t.Errorf("Expected the IP on %s, got %v", b, got)
+	}
+}
+
+func TestIPAddressFailoverSplitBrain(t *testing.T) {
+	cloud, hc, ip, a, b := newFailoverCloud(t)
+	cloud.failures["PUT /instances/"+a+"/network_adapters"] = 503
+
+	res, err := hc.IPAddressFailover(ip, a, b)
+	if err == nil {
+		t.Fatalf("Expected the IP being left on both instances to be an error, got %+v", res)
+	}
+	if res.Detached {
+		t.Errorf("Detach failed but was reported as done")
+	}
+}
+
+func TestIPAddressFailoverHeldElsewhere(t *testing.T) {
+	cloud, hc, ip, a, b := newFailoverCloud(t)
+	c := cloud.addInstance("c", InstanceStateRunning)
+	hc.InstanceDetachIP(a, ip)
+	hc.InstanceAttachIP(c, ip)
+
+	if _, err := hc.IPAddressFailover(ip, a, b); err == nil {
+		t.Fatalf("Expected failing over an IP held by a third instance to fail")
+	}
+	if got := cloud.adapters(b); got != nil {
+		t.Errorf("Expected nothing attached to %s, got %v", b, got)
+	}
+}
+
+func TestFailoverControllerStep(t *testing.T) {
+	_, hc, ip, a, b := newFailoverCloud(t)
+	healthy := map[string]bool{a: true, b: false}
+	check := func(instanceId string) error {
+		if !healthy[instanceId] {
+			return fmt.Errorf("%s is down", instanceId)
+		}
+		return nil
+	}
+	c := hc.NewFailoverController(ip, a, b, check)
+	c.FailureThreshold = 2
+	var failovers []FailoverResult
+	c.OnFailover = func(res FailoverResult, err []error) { failovers = append(failovers, res) }
+
+	c.Step()
+	if c.Active() != a || len(failovers) != 0 {
+		t.Fatalf("Expected %s to stay active, got %s", a, c.Active())
+	}
+
+	// Both down: don't move the IP to an instance that can't serve either
+	healthy[a] = false
+	c.Step()
+	c.Step()
+	if c.Active() != a || len(failovers) != 0 {
+		t.Fatalf("Expected no failover to an unhealthy standby, %d happened", len(failovers))
+	}
+
+	healthy[b] = true
+	c.Step()
+	if c.Active() != b || len(failovers) != 1 {
+		t.Fatalf("Expected a failover to %s, active is %s", b, c.Active())
+	}
+	if holder, _ := hc.IPAddressInstance(ip); holder != b {
+		t.Errorf("Expected the IP on %s, it is on %q", b, holder)
+	}
+}
+
+func TestFailoverControllerAfterSplitBrain(t *testing.T) {
+	cloud, hc, ip, a, b := newFailoverCloud(t)
+	cloud.failures["PUT /instances/"+a+"/network_adapters"] = 503
+	c := hc.NewFailoverController(ip, a, b, func(instanceId string) error {
+		if instanceId == a {
+			return fmt.Errorf("%s is down", a)
+		}
+		return nil
+	})
+	c.FailureThreshold = 1
+	var seen []string
+	c.OnFailover = func(res FailoverResult, err []error) {
+		// Active mustn't block while a round is going
+		done := make(chan string)
+		go func() { done <- c.Active() }()
+		select {
+		case active := <-done:
+			seen = append(seen, active)
+		case <-time.After(time.Second):
+			t.Errorf("Active blocked inside OnFailover")
+		}
+	}
+
+	c.Step()
+	if len(seen) != 1 {
+		t.Fatalf("Expected one failover attempt, got %d", len(seen))
+	}
+	// The IP ended up on both, the API says b has it, so that's the one to watch now
+	holder, _ := hc.IPAddressInstance(ip)
+	if holder != b || c.Active() != holder || seen[0] != holder {
+		t.Errorf("Expected the controller to follow the IP to %s, it has %s (%v)", holder, c.Active(), seen)
+	}
+}
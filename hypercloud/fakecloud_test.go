@@ -0,0 +1,331 @@
+package hypercloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Just enough of the API, kept in memory, to exercise the higher level helpers offline
+type fakeCloud struct {
+	sync.Mutex
+	disks     map[string]map[string]interface{}
+	instances map[string]map[string]interface{}
+	templates map[string]map[string]interface{}
+	ips       map[string]map[string]interface{}
+	keys      map[string]map[string]interface{}
+	networks  map[string]map[string]interface{}
+	nextId    int
+	calls     []string
+	failures  map[string]int           //"METHOD /path" to the status to fail it with
+	lists     map[string][]interface{} //fixed responses for other list endpoints, e.g. "/regions"
+	published map[string]interface{}   //fields set on (nil: removed from) templates as they are published
+}
+
+func newFakeCloud() *fakeCloud {
+	return &fakeCloud{
+		disks:     make(map[string]map[string]interface{}),
+		instances: make(map[string]map[string]interface{}),
+		templates: make(map[string]map[string]interface{}),
+		ips:       make(map[string]map[string]interface{}),
+		keys:      make(map[string]map[string]interface{}),
+		networks:  make(map[string]map[string]interface{}),
+		failures:  make(map[string]int),
+		lists:     make(map[string][]interface{}),
+	}
+}
+
+func (f *fakeCloud) addDisk(name string) string {
+	f.Lock()
+	defer f.Unlock()
+	f.nextId++
+	id := fmt.Sprintf("disk-%d", f.nextId)
+	f.disks[id] = map[string]interface{}{"id": id, "name": name, "state": DiskStateUnattached, "size": 10.0}
+	return id
+}
+
+func (f *fakeCloud) addInstance(name string, state string, disks ...string) string {
+	f.Lock()
+	defer f.Unlock()
+	f.nextId++
+	id := fmt.Sprintf("instance-%d", f.nextId)
+	f.instances[id] = map[string]interface{}{"id": id, "name": name, "state": state, "disks": toInterfaces(disks)}
+	return id
+}
+
+func (f *fakeCloud) addIP(address string, netId string) string {
+	f.Lock()
+	defer f.Unlock()
+	f.nextId++
+	id := fmt.Sprintf("ip-%d", f.nextId)
+	kind := "public"
+	if net.ParseIP(address).IsPrivate() {
+		kind = "private"
+	}
+	f.ips[id] = map[string]interface{}{"id": id, "address": address, "network": netId, "type": kind}
+	return id
+}
+
+func (f *fakeCloud) addNetwork(spec string) string {
+	f.Lock()
+	defer f.Unlock()
+	f.nextId++
+	id := fmt.Sprintf("net-%d", f.nextId)
+	f.networks[id] = map[string]interface{}{"id": id, "specification": spec, "type": "private"}
+	return id
+}
+
+// The instance's adapters as (network, ips...) lists, e.g. adapters(id) == [][]string{{"net-1", "ip-2"}}
+func (f *fakeCloud) adapters(instanceId string) (ret [][]string) {
+	f.Lock()
+	defer f.Unlock()
+	for _, a := range parseNetworkAdapters(f.instances[instanceId]["network_adapters"]) {
+		ret = append(ret, append([]string{a.Network}, a.IPAddresses...))
+	}
+	return
+}
+
+// Templates are created pending and become available after pendingPolls lookups
+func (f *fakeCloud) addTemplate(fields map[string]interface{}) string {
+	f.Lock()
+	defer f.Unlock()
+	f.nextId++
+	id := fmt.Sprintf("template-%d", f.nextId)
+	t := map[string]interface{}{"id": id, "state": "available"}
+	for k, v := range fields {
+		t[k] = v
+	}
+	f.templates[id] = t
+	return id
+}
+
+var pendingPolls = 2
+
+func toInterfaces(s []string) []interface{} {
+	ret := make([]interface{}, len(s))
+	for i := range s {
+		ret[i] = s[i]
+	}
+	return ret
+}
+
+func (f *fakeCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	f.calls = append(f.calls, r.Method+" "+path)
+	if status, ok := f.failures[r.Method+" "+path]; ok {
+		writeJson(w, status, map[string]interface{}{"error": "injected failure"})
+		return
+	}
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if list, ok := f.lists[path]; ok && r.Method == "GET" {
+		writeJson(w, 200, list)
+		return
+	}
+	switch {
+	case r.Method == "GET" && path == "/disks":
+		list := []interface{}{}
+		for _, d := range f.disks {
+			list = append(list, d)
+		}
+		writeJson(w, 200, list)
+	case parts[0] == "disks" && len(parts) >= 2:
+		disk, ok := f.disks[parts[1]]
+		if !ok {
+			writeJson(w, 404, map[string]interface{}{"error": "no such disk"})
+			return
+		}
+		switch {
+		case r.Method == "GET" && len(parts) == 2:
+			writeJson(w, 200, disk)
+		case r.Method == "GET" && parts[2] == "state":
+			writeJson(w, 200, map[string]interface{}{"state": disk["state"]})
+		case r.Method == "POST" && parts[2] == "clone":
+			f.nextId++
+			id := fmt.Sprintf("disk-%d", f.nextId)
+			f.disks[id] = map[string]interface{}{"id": id, "name": body["name"], "state": DiskStateUnattached, "size": disk["size"]}
+			if body["context"] != nil {
+				f.disks[id]["context"] = body["context"]
+			}
+			writeJson(w, 200, f.disks[id])
+		case r.Method == "DELETE":
+			delete(f.disks, parts[1])
+			writeJson(w, 200, map[string]interface{}{})
+		default:
+			writeJson(w, 404, map[string]interface{}{"error": "not found"})
+		}
+	case r.Method == "GET" && path == "/ip_addresses":
+		list := []interface{}{}
+		for _, ip := range f.ips {
+			list = append(list, ip)
+		}
+		writeJson(w, 200, list)
+	case r.Method == "GET" && path == "/ip_addresses/private":
+		list := []interface{}{}
+		for _, ip := range f.ips {
+			if ip["type"] == "private" {
+				list = append(list, ip)
+			}
+		}
+		writeJson(w, 200, list)
+	case r.Method == "GET" && path == "/networks/private":
+		list := []interface{}{}
+		for _, n := range f.networks {
+			list = append(list, n)
+		}
+		writeJson(w, 200, list)
+	case r.Method == "POST" && path == "/networks":
+		f.nextId++
+		id := fmt.Sprintf("net-%d", f.nextId)
+		f.networks[id] = map[string]interface{}{"id": id, "name": body["name"], "specification": body["specification"], "type": "private"}
+		writeJson(w, 200, f.networks[id])
+	case r.Method == "GET" && parts[0] == "networks" && len(parts) == 2:
+		network, ok := f.networks[parts[1]]
+		if !ok {
+			writeJson(w, 404, map[string]interface{}{"error": "no such network"})
+			return
+		}
+		writeJson(w, 200, network)
+	case r.Method == "GET" && parts[0] == "ip_addresses" && len(parts) == 2:
+		ip, ok := f.ips[parts[1]]
+		if !ok {
+			writeJson(w, 404, map[string]interface{}{"error": "no such ip address"})
+			return
+		}
+		writeJson(w, 200, ip)
+	case r.Method == "GET" && path == "/public_keys":
+		list := []interface{}{}
+		for _, k := range f.keys {
+			list = append(list, k)
+		}
+		writeJson(w, 200, list)
+	case r.Method == "POST" && path == "/public_keys":
+		f.nextId++
+		id := fmt.Sprintf("key-%d", f.nextId)
+		f.keys[id] = map[string]interface{}{"id": id, "name": body["name"], "key": body["key"]}
+		writeJson(w, 200, f.keys[id])
+	case r.Method == "DELETE" && parts[0] == "public_keys" && len(parts) == 2:
+		if _, ok := f.keys[parts[1]]; !ok {
+			writeJson(w, 404, map[string]interface{}{"error": "no such public key"})
+			return
+		}
+		delete(f.keys, parts[1])
+		writeJson(w, 200, map[string]interface{}{})
+	case r.Method == "GET" && path == "/templates":
+		list := []interface{}{}
+		for _, t := range f.templates {
+			list = append(list, t)
+		}
+		writeJson(w, 200, list)
+	case r.Method == "POST" && path == "/templates":
+		disk, ok := f.disks[idOf(body["disk"])]
+		if !ok {
+			writeJson(w, 422, map[string]interface{}{"error": "no such disk"})
+			return
+		}
+		f.nextId++
+		id := fmt.Sprintf("template-%d", f.nextId)
+		f.templates[id] = map[string]interface{}{"id": id, "name": body["name"], "slug": body["slug"], "region": disk["region"], "state": "pending", "polls": float64(pendingPolls)}
+		for k, v := range f.published {
+			if v == nil {
+				delete(f.templates[id], k)
+			} else {
+				f.templates[id][k] = v
+			}
+		}
+		if old, ok := f.templates[idOf(body["supersedes"])]; ok {
+			old["superseded_by"] = id
+		}
+		writeJson(w, 200, f.templates[id])
+	case parts[0] == "templates" && len(parts) == 2 && r.Method == "GET":
+		t, ok := f.templates[parts[1]]
+		if !ok {
+			writeJson(w, 404, map[string]interface{}{"error": "no such template"})
+			return
+		}
+		if t["state"] == "pending" {
+			if t["polls"] = t["polls"].(float64) - 1; t["polls"].(float64) < 0 {
+				t["state"] = "available"
+			}
+		}
+		writeJson(w, 200, t)
+	case r.Method == "GET" && path == "/instances":
+		list := []interface{}{}
+		for _, i := range f.instances {
+			list = append(list, i)
+		}
+		writeJson(w, 200, list)
+	case parts[0] == "instances" && len(parts) >= 2:
+		inst, ok := f.instances[parts[1]]
+		if !ok {
+			writeJson(w, 404, map[string]interface{}{"error": "no such instance"})
+			return
+		}
+		switch {
+		case r.Method == "GET" && len(parts) == 2:
+			writeJson(w, 200, inst)
+		case r.Method == "GET" && parts[2] == "state":
+			writeJson(w, 200, map[string]interface{}{"state": inst["state"]})
+		case r.Method == "GET" && parts[2] == "context":
+			ctx := inst["context"]
+			if ctx == nil {
+				ctx = map[string]interface{}{}
+			}
+			writeJson(w, 200, ctx)
+		case r.Method == "POST" && parts[2] == "start":
+			inst["state"] = InstanceStateRunning
+			writeJson(w, 200, inst)
+		case r.Method == "POST" && parts[2] == "stop":
+			inst["state"] = InstanceStateStopped
+			writeJson(w, 200, inst)
+		case r.Method == "DELETE" && len(parts) == 2:
+			delete(f.instances, parts[1])
+			writeJson(w, 200, map[string]interface{}{})
+		case r.Method == "PUT" && parts[2] == "network_adapters":
+			adapters := parseNetworkAdapters(body["network_adapters"])
+			for _, a := range adapters {
+				for _, id := range a.IPAddresses {
+					if ip, ok := f.ips[id]; !ok || ip["network"] != a.Network {
+						writeJson(w, 422, map[string]interface{}{"error": "ip address " + id + " is not on network " + a.Network})
+						return
+					}
+				}
+			}
+			for _, ip := range f.ips {
+				if ip["instance"] == parts[1] {
+					delete(ip, "instance")
+				}
+			}
+			for _, a := range adapters {
+				for _, id := range a.IPAddresses {
+					f.ips[id]["instance"] = parts[1]
+				}
+			}
+			inst["network_adapters"] = body["network_adapters"]
+			writeJson(w, 200, inst)
+		case r.Method == "PUT" && parts[2] == "availability_group":
+			inst["availability_groups"] = body["availability_groups"]
+			writeJson(w, 200, inst)
+		case r.Method == "PUT" && parts[2] == "disks":
+			for _, d := range sliceOf(body["disks"]) {
+				if _, ok := f.disks[idOf(d)]; !ok {
+					writeJson(w, 422, map[string]interface{}{"error": "no such disk " + idOf(d)})
+					return
+				}
+			}
+			inst["disks"] = body["disks"]
+			writeJson(w, 200, inst)
+		default:
+			writeJson(w, 404, map[string]interface{}{"error": "not found"})
+		}
+	default:
+		writeJson(w, 404, map[string]interface{}{"error": "not found"})
+	}
+}
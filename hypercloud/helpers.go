@@ -0,0 +1,76 @@
+package hypercloud
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/* Small helpers for digging values out of the decoded json without panicking on a bad type assertion */
+
+func mapOf(data interface{}) map[string]interface{} {
+	if m, ok := data.(map[string]interface{}); ok {
+		return m
+	}
+	return nil
+}
+
+func sliceOf(data interface{}) []interface{} {
+	if s, ok := data.([]interface{}); ok {
+		return s
+	}
+	return nil
+}
+
+func stringOf(data interface{}, key string) string {
+	m := mapOf(data)
+	if m == nil {
+		return ""
+	}
+	switch v := m[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func numberOf(data interface{}, key string) float64 {
+	m := mapOf(data)
+	if m == nil {
+		return 0
+	}
+	switch v := m[key].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// Resources are returned either as an id string or as an embedded object with an "id" key
+func idOf(data interface{}) string {
+	if s, ok := data.(string); ok {
+		return s
+	}
+	return stringOf(data, "id")
+}
+
+// How long to sleep between polls when waiting on a resource to change state
+var pollInterval = 2 * time.Second
+
+// Eight hex characters, for tying names we generate back to an id
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:8]
+}
@@ -2,8 +2,10 @@ package hypercloud
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -17,6 +19,17 @@ type hypercloud struct {
 	baseUrl string
 
 	client *http.Client
+
+	policies  []Policy
+	overrides []string //policy rules lifted on this copy, see Override
+	dryRun    *dryRunState
+	audit     *auditState
+	logger    *slog.Logger
+
+	limitsPath string //see SetAccountLimitsPath
+
+	instrument Instrumentation
+	ctx        context.Context //parent for instrumentation, set on workflow copies
 }
 
 func ToHypercloud(data interface{}) hypercloud {
@@ -24,7 +37,7 @@ func ToHypercloud(data interface{}) hypercloud {
 }
 
 func NewHypercloud(url string, token string) (hc hypercloud, erro []error) {
-	var ret = hypercloud{token, url, nil}
+	var ret = hypercloud{token: token, baseUrl: url}
 	ret.client = &http.Client{
 		Timeout: 25 * time.Second,
 	}
@@ -33,9 +46,41 @@ func NewHypercloud(url string, token string) (hc hypercloud, erro []error) {
 }
 
 func (h *hypercloud) Request(method string, url string, data interface{}) (rVal interface{}, err []error) {
+	rVal, _, err = h.request(method, url, data)
+	return
+}
+
+// Request, also returning the HTTP status for callers which treat some errors
+// differently. The status is 0 when nothing was sent.
+func (h *hypercloud) request(method string, url string, data interface{}) (rVal interface{}, status int, err []error) {
 	//Normalize method
 	method = strings.ToUpper(method)
-	json, body, status := h._request(method, url, data)
+	if method != "GET" && len(h.policies) > 0 {
+		if err = h.checkPolicies(method, url, data); err != nil {
+			if h.logger != nil {
+				h.logger.Warn("hypercloud request denied by policy", "method", method, "path", url, "error", err[0].Error())
+			}
+			if h.audit != nil {
+				h.auditRequest(method, url, data, nil, 0, 0, err[0])
+			}
+			return
+		}
+	}
+	if method != "GET" && h.dryRun != nil {
+		rVal = h.dryRunRequest(method, url, data)
+		if h.logger != nil {
+			h.logger.Debug("hypercloud request skipped (dry run)", "method", method, "path", url)
+		}
+		return
+	}
+	start := time.Now()
+	json, body, status, header := h._request(method, url, data)
+	if method != "GET" && h.audit != nil {
+		h.auditRequest(method, url, data, json, status, time.Since(start), nil)
+	}
+	if h.logger != nil {
+		h.logRequest(method, url, status, json, body, header, time.Since(start))
+	}
 
 	rVal = json
 	if 200 <= status && status < 300 {
@@ -56,7 +101,18 @@ func (h *hypercloud) Request(method string, url string, data interface{}) (rVal
 	return
 }
 
-func (h *hypercloud) _request(method string, url string, data interface{}) (json interface{}, body string, status int) {
+func (h *hypercloud) requestHeaders() http.Header {
+	header := make(http.Header)
+	header["Authorization"] = []string{"Bearer " + h.token}
+	header["User-agent"] = []string{"Generated Client (golang)"}
+	header["Content-type"] = []string{"application/json"}
+	header["Accept"] = []string{"application/json"}
+	return header
+}
+
+func (h *hypercloud) _request(method string, url string, data interface{}) (json interface{}, body string, status int, header http.Header) {
+	end := h.startRequest(method, url)
+	defer func() { end(status) }()
 	url = h.baseUrl + "/api/v1" + url
 	var req *http.Request
 	if data != nil {
@@ -85,10 +141,7 @@ func (h *hypercloud) _request(method string, url string, data interface{}) (json
 		}
 	}
 
-	req.Header["Authorization"] = []string{"Bearer " + h.token}
-	req.Header["User-agent"] = []string{"Generated Client (golang)"}
-	req.Header["Content-type"] = []string{"application/json"}
-	req.Header["Accept"] = []string{"application/json"}
+	req.Header = h.requestHeaders()
 
 	resp, err := h.client.Do(req)
 	if err != nil {
@@ -98,6 +151,7 @@ func (h *hypercloud) _request(method string, url string, data interface{}) (json
 		return
 	}
 	defer resp.Body.Close()
+	header = resp.Header
 	mData, err := ioutil.ReadAll(resp.Body)
 	err = Json.Unmarshal(mData, &json)
 	status = resp.StatusCode
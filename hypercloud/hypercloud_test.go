@@ -270,7 +270,7 @@ func TestHypercloud(t *testing.T) {
 	}
 	adapInfo, err := hc.NetworkInfo(mNetAdapter)
 	if err != nil {
-		t.Logf("Failed to grab network info: \n%v")
+		t.Logf("Failed to grab network info: \n%v", err)
 		t.FailNow()
 	}
 	if adapInfo.(map[string]interface{})["state"] != "ready" {
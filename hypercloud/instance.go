@@ -32,6 +32,8 @@ func (h *hypercloud) InstanceList() (ret interface{}, err []error) {
    - Networking
 */
 func (h *hypercloud) InstanceUpdate(instanceId string, body interface{}) (ret interface{}, err []error) {
+	h, done := h.workflow("InstanceUpdate", map[string]string{"instance_id": instanceId})
+	defer func() { done(err) }()
 	/* Time to munch up the following keys and shove them into the correct functions */
 	dat := body.(map[string]interface{})
 	if val, ok := dat["availability_groups"]; ok {
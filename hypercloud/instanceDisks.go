@@ -0,0 +1,233 @@
+package hypercloud
+
+import (
+	"fmt"
+	"time"
+)
+
+// The first disk in an instance's list is the one it boots from, so the order
+// of the list matters. These helpers edit the list one disk at a time, stop the
+// instance around changes to the boot disk or the order and put the original
+// list back if anything fails.
+
+// What happened to an instance's disks
+type DiskChangeResult struct {
+	InstanceId string
+	Before     []string
+	After      []string
+	Stopped    bool //the instance had to be stopped for the change
+	Restarted  bool
+	RolledBack bool //the change failed and the original list was put back
+}
+
+// The ids of the disks attached to an instance, boot disk first
+func (h *hypercloud) InstanceDisks(instanceId string) (disks []string, err []error) {
+	info, err := h.InstanceInfo(instanceId)
+	if err != nil {
+		return
+	}
+	for _, d := range sliceOf(mapOf(info)["disks"]) {
+		disks = append(disks, idOf(d))
+	}
+	return
+}
+
+// Puts newDiskId in oldDiskId's place, keeping the boot order
+func (h *hypercloud) InstanceDiskReplace(instanceId string, oldDiskId string, newDiskId string, timeout time.Duration) (res DiskChangeResult, err []error) {
+	disks, err := h.InstanceDisks(instanceId)
+	if err != nil {
+		return
+	}
+	i := indexOf(disks, oldDiskId)
+	if i < 0 {
+		err = append(err, fmt.Errorf("Disk %s is not attached to instance %s", oldDiskId, instanceId))
+		return
+	}
+	if err = h.checkAttachable(disks, newDiskId); err != nil {
+		return
+	}
+	updated := append([]string(nil), disks...)
+	updated[i] = newDiskId
+	return h.InstanceSetDisks(instanceId, updated, timeout)
+}
+
+// Attaches a disk, as the boot disk if boot is set, otherwise after the existing ones
+func (h *hypercloud) InstanceDiskAttach(instanceId string, diskId string, boot bool, timeout time.Duration) (res DiskChangeResult, err []error) {
+	disks, err := h.InstanceDisks(instanceId)
+	if err != nil {
+		return
+	}
+	if err = h.checkAttachable(disks, diskId); err != nil {
+		return
+	}
+	updated := append([]string(nil), disks...)
+	if boot {
+		updated = append([]string{diskId}, updated...)
+	} else {
+		updated = append(updated, diskId)
+	}
+	return h.InstanceSetDisks(instanceId, updated, timeout)
+}
+
+// Detaches a disk. The boot disk can only be detached if it is the instance's
+// only disk, otherwise the next one would silently become the boot disk; use
+// InstanceDiskReplace or InstanceSetDisks for that.
+func (h *hypercloud) InstanceDiskDetach(instanceId string, diskId string, timeout time.Duration) (res DiskChangeResult, err []error) {
+	disks, err := h.InstanceDisks(instanceId)
+	if err != nil {
+		return
+	}
+	i := indexOf(disks, diskId)
+	if i < 0 {
+		err = append(err, fmt.Errorf("Disk %s is not attached to instance %s", diskId, instanceId))
+		return
+	}
+	if i == 0 && len(disks) > 1 {
+		err = append(err, fmt.Errorf("Disk %s is the boot disk of instance %s, detaching it would boot from %s", diskId, instanceId, disks[1]))
+		return
+	}
+	updated := append(append([]string(nil), disks[:i]...), disks[i+1:]...)
+	return h.InstanceSetDisks(instanceId, updated, timeout)
+}
+
+// Replaces the instance's whole disk list (boot disk first). A running instance
+// is only stopped (and started again afterwards) if the boot disk or the order
+// of the disks it keeps changes, adding or removing other disks is done while it
+// runs. If the update fails, or the instance doesn't end up with the requested
+// list, the original list is restored. In dry run mode the stop and start are
+// recorded without waiting on them and the result isn't checked.
+func (h *hypercloud) InstanceSetDisks(instanceId string, disks []string, timeout time.Duration) (res DiskChangeResult, err []error) {
+	res.InstanceId = instanceId
+	res.Before, err = h.InstanceDisks(instanceId)
+	if err != nil {
+		return
+	}
+	res.After = res.Before
+	if equalStrings(res.Before, disks) {
+		return
+	}
+
+	if needsStop(res.Before, disks) {
+		if res.Stopped, err = h.stopForDisks(instanceId, timeout); err != nil {
+			return
+		}
+	}
+
+	err = h.updateDisks(instanceId, disks)
+	if err != nil {
+		if erro := h.updateDisks(instanceId, res.Before); erro != nil {
+			err = append(err, fmt.Errorf("Unable to restore the original disks of instance %s: %v", instanceId, erro))
+		} else {
+			res.RolledBack = true
+		}
+	} else {
+		res.After = disks
+	}
+
+	if res.Stopped {
+		var erro []error
+		if h.IsDryRun() {
+			_, erro = h.InstanceStart(instanceId, nil)
+		} else {
+			_, erro = h.InstancePowerOn(instanceId, timeout)
+		}
+		if erro != nil {
+			err = append(err, erro...)
+		} else {
+			res.Restarted = true
+		}
+	}
+	return
+}
+
+// Whether going from one disk list to the other changes the boot disk or the
+// order of the disks in both
+func needsStop(before []string, after []string) bool {
+	boot := func(disks []string) string {
+		if len(disks) == 0 {
+			return ""
+		}
+		return disks[0]
+	}
+	if boot(before) != boot(after) {
+		return true
+	}
+	kept := func(disks []string, other []string) (list []string) {
+		for _, d := range disks {
+			if indexOf(other, d) >= 0 {
+				list = append(list, d)
+			}
+		}
+		return
+	}
+	return !equalStrings(kept(before, after), kept(after, before))
+}
+
+// Stops a running instance, saying whether it had to
+func (h *hypercloud) stopForDisks(instanceId string, timeout time.Duration) (stopped bool, err []error) {
+	if !h.IsDryRun() {
+		power, err := h.InstanceShutdown(instanceId, timeout)
+		return err == nil && power.Initial != InstanceStateStopped, err
+	}
+	// Nothing will change state, so there is nothing to wait for
+	state, err := h.InstanceCurrentState(instanceId)
+	if err != nil || state == InstanceStateStopped {
+		return
+	}
+	_, err = h.InstanceStop(instanceId, map[string]interface{}{"force": false})
+	return err == nil, err
+}
+
+// Pushes the list and checks the instance actually has it afterwards (unless it's a dry run)
+func (h *hypercloud) updateDisks(instanceId string, disks []string) (err []error) {
+	if disks == nil {
+		disks = []string{}
+	}
+	if _, err = h.InstanceUpdateDisks(instanceId, map[string]interface{}{"disks": disks}); err != nil || h.IsDryRun() {
+		return
+	}
+	current, err := h.InstanceDisks(instanceId)
+	if err != nil {
+		return
+	}
+	if !equalStrings(current, disks) {
+		err = append(err, fmt.Errorf("Instance %s has disks %v after the update, expected %v", instanceId, current, disks))
+	}
+	return
+}
+
+func (h *hypercloud) checkAttachable(attached []string, diskId string) (err []error) {
+	if indexOf(attached, diskId) >= 0 {
+		err = append(err, fmt.Errorf("Disk %s is already attached to this instance", diskId))
+		return
+	}
+	disk, err := h.DiskInfo(diskId)
+	if err != nil {
+		return
+	}
+	if state := stringOf(disk, "state"); state != DiskStateUnattached {
+		err = append(err, fmt.Errorf("Disk %s can't be attached while %s", diskId, state))
+	}
+	return
+}
+
+func indexOf(list []string, s string) int {
+	for i := range list {
+		if list[i] == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
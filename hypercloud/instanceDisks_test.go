@@ -0,0 +1,137 @@
+package hypercloud
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInstanceDiskReplace(t *testing.T) {
+	pollInterval = time.Millisecond
+	cloud := newFakeCloud()
+	boot := cloud.addDisk("boot")
+	data := cloud.addDisk("data")
+	restored := cloud.addDisk("data-snap-20170301t120000")
+	inst := cloud.addInstance("web", InstanceStateRunning, boot, data)
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	res, err := hc.InstanceDiskReplace(inst, data, restored, time.Second)
+	if err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if !equalStrings(res.After, []string{boot, restored}) {
+		t.Errorf("Expected boot disk to stay first, got %v", res.After)
+	}
+	// The boot disk stays put, so there's no need to stop the instance
+	if res.Stopped || res.Restarted || res.RolledBack {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+	if state := cloud.instances[inst]["state"]; state != InstanceStateRunning {
+		t.Errorf("Expected instance to keep running, it is %v", state)
+	}
+
+	// A new boot disk does need it
+	res, err = hc.InstanceDiskReplace(inst, boot, cloud.addDisk("boot-2"), time.Second)
+	if err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if !res.Stopped || !res.Restarted || res.RolledBack {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+	if state := cloud.instances[inst]["state"]; state != InstanceStateRunning {
+		t.Errorf("Expected instance to be running again, it is %v", state)
+	}
+}
+
+func TestNeedsStop(t *testing.T) {
+	tests := []struct {
+		before, after []string
+		stop          bool
+	}{
+		{[]string{"a", "b"}, []string{"a", "b", "c"}, false},
+		{[]string{"a", "b", "c"}, []string{"a", "c"}, false},
+		{[]string{"a", "b"}, []string{"a", "c"}, false},
+		{[]string{"a", "b", "c"}, []string{"a", "c", "b"}, true},
+		{[]string{"a", "b"}, []string{"c", "a", "b"}, true},
+		{[]string{"a"}, nil, true},
+		{nil, []string{"a"}, true},
+	}
+	for _, tt := range tests {
+		if stop := needsStop(tt.before, tt.after); stop != tt.stop {
+			t.Errorf("%v to %v: expected %v, got %v", tt.before, tt.after, tt.stop, stop)
+		}
+	}
+}
+
+func TestInstanceSetDisksDryRun(t *testing.T) {
+	cloud := newFakeCloud()
+	boot := cloud.addDisk("boot")
+	data := cloud.addDisk("data")
+	inst := cloud.addInstance("web", InstanceStateRunning, boot, data)
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	plan := hc.Plan()
+	res, err := plan.InstanceSetDisks(inst, []string{data, boot}, time.Minute)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if !res.Stopped || !res.Restarted || res.RolledBack {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+	var calls []string
+	for _, c := range plan.DryRunCalls() {
+		calls = append(calls, c.Method+" "+c.Path)
+	}
+	want := []string{"POST /instances/" + inst + "/stop", "PUT /instances/" + inst + "/disks", "POST /instances/" + inst + "/start"}
+	if !equalStrings(calls, want) {
+		t.Errorf("Expected %v, got %v", want, calls)
+	}
+	if current, _ := hc.InstanceDisks(inst); !equalStrings(current, []string{boot, data}) || cloud.instances[inst]["state"] != InstanceStateRunning {
+		t.Errorf("Expected the dry run to leave the instance alone, it has %v and is %v", current, cloud.instances[inst]["state"])
+	}
+}
+
+func TestInstanceDiskDetachBootDisk(t *testing.T) {
+	cloud := newFakeCloud()
+	boot := cloud.addDisk("boot")
+	data := cloud.addDisk("data")
+	inst := cloud.addInstance("web", InstanceStateStopped, boot, data)
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	if _, err := hc.InstanceDiskDetach(inst, boot, time.Second); err == nil {
+		t.Errorf("Expected detaching the boot disk to be refused")
+	}
+	res, err := hc.InstanceDiskDetach(inst, data, time.Second)
+	if err != nil {
+		t.Fatalf("Detach failed: %v", err)
+	}
+	if res.Stopped || !equalStrings(res.After, []string{boot}) {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+}
+
+func TestInstanceSetDisksRollsBack(t *testing.T) {
+	pollInterval = time.Millisecond
+	cloud := newFakeCloud()
+	boot := cloud.addDisk("boot")
+	data := cloud.addDisk("data")
+	inst := cloud.addInstance("web", InstanceStateRunning, boot, data)
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	// The API refuses the list, so the original one is put back
+	res, err := hc.InstanceSetDisks(inst, []string{data, boot, "disk-missing"}, time.Second)
+	if err == nil {
+		t.Fatalf("Expected the refused update to be reported")
+	}
+	if !res.RolledBack || !res.Stopped || !res.Restarted {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+	if !equalStrings(res.Before, []string{boot, data}) || !equalStrings(res.After, res.Before) {
+		t.Errorf("Expected the result to report the original order, got %+v", res)
+	}
+	if current, _ := hc.InstanceDisks(inst); !equalStrings(current, []string{boot, data}) {
+		t.Errorf("Expected the original disks in their original order, got %v", current)
+	}
+	if state := cloud.instances[inst]["state"]; state != InstanceStateRunning {
+		t.Errorf("Expected instance to be running again, it is %v", state)
+	}
+}
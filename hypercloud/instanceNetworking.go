@@ -0,0 +1,212 @@
+package hypercloud
+
+import (
+	"fmt"
+)
+
+// One entry of an instance's network_adapters: a network and the IPs on it
+type NetworkAdapter struct {
+	Network     string
+	IPAddresses []string
+}
+
+// The instance's adapters as the ids InstanceUpdateNetworking expects
+func (h *hypercloud) InstanceNetworkAdapters(instanceId string) (adapters []NetworkAdapter, err []error) {
+	info, err := h.InstanceInfo(instanceId)
+	if err != nil {
+		return
+	}
+	adapters = parseNetworkAdapters(mapOf(info)["network_adapters"])
+	return
+}
+
+func parseNetworkAdapters(data interface{}) (adapters []NetworkAdapter) {
+	for _, a := range sliceOf(data) {
+		adapter := NetworkAdapter{Network: idOf(mapOf(a)["network"])}
+		if adapter.Network == "" {
+			adapter.Network = stringOf(a, "network_id")
+		}
+		for _, ip := range sliceOf(mapOf(a)["ip_addresses"]) {
+			adapter.IPAddresses = append(adapter.IPAddresses, idOf(ip))
+		}
+		adapters = append(adapters, adapter)
+	}
+	return
+}
+
+// The network an IP address belongs to
+func (h *hypercloud) IPAddressNetwork(IPAddrID string) (netId string, err []error) {
+	ip, err := h.IPAddressInfo(IPAddrID)
+	if err != nil {
+		return
+	}
+	if netId = stringOf(ip, "network_id"); netId == "" {
+		netId = idOf(mapOf(ip)["network"])
+	}
+	if netId == "" {
+		err = append(err, fmt.Errorf("Unable to determine the network of IP address %s", IPAddrID))
+	}
+	return
+}
+
+// Attaches an IP to the instance, on the adapter for the IP's network (added if missing)
+func (h *hypercloud) InstanceAttachIP(instanceId string, IPAddrID string) (ret interface{}, err []error) {
+	netId, err := h.IPAddressNetwork(IPAddrID)
+	if err != nil {
+		return
+	}
+	adapters, err := h.InstanceNetworkAdapters(instanceId)
+	if err != nil {
+		return
+	}
+	for _, a := range adapters {
+		if indexOf(a.IPAddresses, IPAddrID) >= 0 {
+			err = append(err, fmt.Errorf("IP address %s is already attached to instance %s", IPAddrID, instanceId))
+			return
+		}
+	}
+	adapters = append(adapters, NetworkAdapter{netId, []string{IPAddrID}})
+	return h.InstanceSetNetworkAdapters(instanceId, adapters)
+}
+
+// Removes an IP from whichever adapter it is on. The adapter itself stays.
+func (h *hypercloud) InstanceDetachIP(instanceId string, IPAddrID string) (ret interface{}, err []error) {
+	adapters, err := h.InstanceNetworkAdapters(instanceId)
+	if err != nil {
+		return
+	}
+	found := false
+	for i := range adapters {
+		if j := indexOf(adapters[i].IPAddresses, IPAddrID); j >= 0 {
+			adapters[i].IPAddresses = append(adapters[i].IPAddresses[:j:j], adapters[i].IPAddresses[j+1:]...)
+			found = true
+		}
+	}
+	if !found {
+		err = append(err, fmt.Errorf("IP address %s is not attached to instance %s", IPAddrID, instanceId))
+		return
+	}
+	return h.InstanceSetNetworkAdapters(instanceId, adapters)
+}
+
+// Adds an adapter on a network, optionally with some of that network's IPs
+func (h *hypercloud) InstanceAddAdapter(instanceId string, netId string, IPAddrIDs ...string) (ret interface{}, err []error) {
+	adapters, err := h.InstanceNetworkAdapters(instanceId)
+	if err != nil {
+		return
+	}
+	for _, a := range adapters {
+		if a.Network == netId {
+			err = append(err, fmt.Errorf("Instance %s already has an adapter on network %s", instanceId, netId))
+			return
+		}
+	}
+	adapters = append(adapters, NetworkAdapter{netId, IPAddrIDs})
+	return h.InstanceSetNetworkAdapters(instanceId, adapters)
+}
+
+// Removes the adapter on a network along with all of its IPs
+func (h *hypercloud) InstanceRemoveAdapter(instanceId string, netId string) (ret interface{}, err []error) {
+	adapters, err := h.InstanceNetworkAdapters(instanceId)
+	if err != nil {
+		return
+	}
+	var kept []NetworkAdapter
+	for _, a := range adapters {
+		if a.Network != netId {
+			kept = append(kept, a)
+		}
+	}
+	if len(kept) == len(adapters) {
+		err = append(err, fmt.Errorf("Instance %s has no adapter on network %s", instanceId, netId))
+		return
+	}
+	return h.InstanceSetNetworkAdapters(instanceId, kept)
+}
+
+// Groups the IPs onto one adapter per network (looking up which network each
+// IP is on), pushes the result with InstanceUpdateNetworking and checks the
+// instance ended up with it.
+func (h *hypercloud) InstanceSetNetworkAdapters(instanceId string, adapters []NetworkAdapter) (ret interface{}, err []error) {
+	adapters, err = h.groupAdapters(adapters)
+	if err != nil {
+		return
+	}
+	payload := []interface{}{}
+	for _, a := range adapters {
+		ips := a.IPAddresses
+		if ips == nil {
+			ips = []string{}
+		}
+		payload = append(payload, map[string]interface{}{"network": a.Network, "ip_addresses": ips})
+	}
+	ret, err = h.InstanceUpdateNetworking(instanceId, map[string]interface{}{"network_adapters": payload})
+	if err != nil {
+		return
+	}
+	current, err := h.InstanceNetworkAdapters(instanceId)
+	if err != nil {
+		return
+	}
+	if !sameAdapters(current, adapters) {
+		err = append(err, fmt.Errorf("Instance %s has network adapters %v after the update, expected %v", instanceId, current, adapters))
+	}
+	return
+}
+
+// Moves every IP onto the adapter for its own network, merging adapters on the same network
+func (h *hypercloud) groupAdapters(adapters []NetworkAdapter) (grouped []NetworkAdapter, err []error) {
+	byNetwork := make(map[string]int)
+	seen := make(map[string]bool)
+	add := func(netId string) int {
+		if i, ok := byNetwork[netId]; ok {
+			return i
+		}
+		grouped = append(grouped, NetworkAdapter{Network: netId})
+		byNetwork[netId] = len(grouped) - 1
+		return len(grouped) - 1
+	}
+	for _, a := range adapters {
+		if a.Network != "" {
+			add(a.Network)
+		}
+		for _, ip := range a.IPAddresses {
+			if seen[ip] {
+				continue
+			}
+			seen[ip] = true
+			netId, erro := h.IPAddressNetwork(ip)
+			if erro != nil {
+				err = append(err, erro...)
+				continue
+			}
+			i := add(netId)
+			grouped[i].IPAddresses = append(grouped[i].IPAddresses, ip)
+		}
+	}
+	return
+}
+
+// Same networks with the same IPs, ignoring order
+func sameAdapters(a []NetworkAdapter, b []NetworkAdapter) bool {
+	flatten := func(adapters []NetworkAdapter) map[string]bool {
+		m := make(map[string]bool)
+		for _, adapter := range adapters {
+			m["net:"+adapter.Network] = true
+			for _, ip := range adapter.IPAddresses {
+				m[adapter.Network+"/"+ip] = true
+			}
+		}
+		return m
+	}
+	fa, fb := flatten(a), flatten(b)
+	if len(fa) != len(fb) {
+		return false
+	}
+	for k := range fa {
+		if !fb[k] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package hypercloud
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInstanceAttachDetachIP(t *testing.T) {
+	cloud := newFakeCloud()
+	private := cloud.addIP("10.0.0.5", "net-private")
+	public := cloud.addIP("203.0.113.5", "net-public")
+	second := cloud.addIP("10.0.0.6", "net-private")
+	inst := cloud.addInstance("web", InstanceStateRunning)
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	for _, ip := range []string{private, public, second} {
+		if _, err := hc.InstanceAttachIP(inst, ip); err != nil {
+			t.Fatalf("Attaching %s failed: %v", ip, err)
+		}
+	}
+	want := [][]string{{"net-private", private, second}, {"net-public", public}}
+	if got := cloud.adapters(inst); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected one adapter per network, got %v", got)
+	}
+	if _, err := hc.InstanceAttachIP(inst, public); err == nil {
+		t.Errorf("Expected attaching an IP twice to fail")
+	}
+
+	if _, err := hc.InstanceDetachIP(inst, private); err != nil {
+		t.Fatalf("Detach failed: %v", err)
+	}
+	want = [][]string{{"net-private", second}, {"net-public", public}}
+	if got := cloud.adapters(inst); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected just the IP to go, got %v", got)
+	}
+	if cloud.ips[private]["instance"] != nil {
+		t.Errorf("Detached IP still belongs to the instance")
+	}
+
+	if _, err := hc.InstanceRemoveAdapter(inst, "net-public"); err != nil {
+		t.Fatalf("Removing the adapter failed: %v", err)
+	}
+	want = [][]string{{"net-private", second}}
+	if got := cloud.adapters(inst); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the public adapter to be gone, got %v", got)
+	}
+	if _, err := hc.InstanceRemoveAdapter(inst, "net-public"); err == nil {
+		t.Errorf("Expected removing a missing adapter to fail")
+	}
+}
+
+func TestGroupAdapters(t *testing.T) {
+	cloud := newFakeCloud()
+	a := cloud.addIP("10.0.0.5", "net-1")
+	b := cloud.addIP("10.0.1.5", "net-2")
+	c := cloud.addIP("10.0.0.6", "net-1")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	grouped, err := hc.groupAdapters([]NetworkAdapter{
+		{Network: "net-2", IPAddresses: []string{a}}, //on the wrong adapter
+		{Network: "net-1", IPAddresses: []string{b, c, a}},
+		{Network: "net-3"}, //empty adapters are kept
+	})
+	if err != nil {
+		t.Fatalf("Grouping failed: %v", err)
+	}
+	want := []NetworkAdapter{
+		{Network: "net-2", IPAddresses: []string{b}},
+		{Network: "net-1", IPAddresses: []string{a, c}},
+		{Network: "net-3"},
+	}
+	if !reflect.DeepEqual(grouped, want) {
+		t.Errorf("Expected %v, got %v", want, grouped)
+	}
+	if !sameAdapters(grouped, []NetworkAdapter{want[2], want[1], {Network: "net-2", IPAddresses: []string{b}}}) {
+		t.Errorf("Expected adapter order not to matter")
+	}
+
+	if _, err := hc.groupAdapters([]NetworkAdapter{{Network: "net-1", IPAddresses: []string{"ip-missing"}}}); err == nil {
+		t.Errorf("Expected an unknown IP to fail")
+	}
+}
@@ -0,0 +1,127 @@
+package hypercloud
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Hooks for tracing and metrics. The client doesn't depend on any telemetry
+// library itself, the otelhc package implements these with OpenTelemetry.
+//
+// Every HTTP call is reported through StartRequest, including the lookups
+// policies and dry run mode make. Helpers made up of several calls (InstanceUpdate,
+// the wait loops) report a workflow, and the calls they make are started with
+// the workflow's context so they end up as its children.
+
+type RequestInfo struct {
+	Method       string
+	Path         string
+	ResourceType string //first path segment, e.g. "instances"
+	Operation    string //list, get, create, update, delete or the action, e.g. "start"
+}
+
+type RequestOutcome struct {
+	Status     int
+	Attempt    int    //requests are currently sent once, so always 1
+	ErrorClass string //empty on success, see errorClass
+	Duration   time.Duration
+}
+
+type Instrumentation interface {
+	StartRequest(ctx context.Context, info RequestInfo) func(RequestOutcome)
+	StartWorkflow(ctx context.Context, name string, attrs map[string]string) (context.Context, func(err []error))
+}
+
+// Reports requests and workflows to i, or stops reporting if i is nil
+func (h *hypercloud) SetInstrumentation(i Instrumentation) {
+	h.instrument = i
+	h.ctx = nil
+}
+
+func (h *hypercloud) context() context.Context {
+	if h.ctx == nil {
+		return context.Background()
+	}
+	return h.ctx
+}
+
+// Starts a workflow, returning a copy of the client whose requests belong to it.
+// Callers shadow their receiver with the copy:
+//
+//	h, done := h.workflow("InstanceUpdate", map[string]string{"instance_id": instanceId})
+//	defer func() { done(err) }()
+func (h *hypercloud) workflow(name string, attrs map[string]string) (*hypercloud, func([]error)) {
+	if h.instrument == nil {
+		return h, func([]error) {}
+	}
+	child := *h
+	var end func([]error)
+	child.ctx, end = h.instrument.StartWorkflow(h.context(), name, attrs)
+	return &child, end
+}
+
+func (h *hypercloud) startRequest(method string, path string) func(status int) {
+	if h.instrument == nil {
+		return func(int) {}
+	}
+	info := RequestInfo{Method: method, Path: path, Operation: operationOf(method, path)}
+	info.ResourceType, _ = parseResourcePath(path)
+	start := time.Now()
+	end := h.instrument.StartRequest(h.context(), info)
+	return func(status int) {
+		end(RequestOutcome{Status: status, Attempt: 1, ErrorClass: errorClass(status), Duration: time.Since(start)})
+	}
+}
+
+// What a request does, from its method and path:
+//
+//	GET /instances             list
+//	GET /instances/id          get
+//	POST /instances/assemble   create
+//	PUT /instances/id          update
+//	POST /instances/id/start   start
+func operationOf(method string, path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) >= 3:
+		return parts[2]
+	case len(parts) == 1 && method == "GET":
+		return "list"
+	case len(parts) == 1 && method == "POST":
+		return "create"
+	case len(parts) >= 2 && createActions[parts[1]]:
+		return "create"
+	}
+	switch method {
+	case "GET":
+		return "get"
+	case "PUT", "PATCH":
+		return "update"
+	case "DELETE":
+		return "delete"
+	}
+	return strings.ToLower(method)
+}
+
+// The kind of failure a status means, matching the errors Request returns.
+// Transport failures are reported by _request as 503, so land in "unavailable".
+func errorClass(status int) string {
+	switch {
+	case 200 <= status && status < 300:
+		return ""
+	case status == 401:
+		return "authentication"
+	case status == 403:
+		return "unauthorized"
+	case status == 400 || status == 404:
+		return "invalid_request"
+	case status == 422:
+		return "validation"
+	case status == 503:
+		return "unavailable"
+	case status >= 500:
+		return "server"
+	}
+	return "api"
+}
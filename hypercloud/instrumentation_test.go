@@ -0,0 +1,31 @@
+package hypercloud
+
+import (
+	"testing"
+)
+
+func TestOperationOf(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/instances", "list"},
+		{"GET", "/instances/i-1", "get"},
+		{"POST", "/instances", "create"},
+		{"POST", "/instances/assemble", "create"},
+		{"POST", "/ip_addresses/public", "create"},
+		{"PUT", "/instances/i-1", "update"},
+		{"DELETE", "/instances/i-1", "delete"},
+		{"POST", "/instances/i-1/start", "start"},
+		{"DELETE", "/instances/i-1/context/owner", "context"},
+		{"PUT", "/instances", "update"},
+		{"DELETE", "/instances", "delete"},
+		{"DELETE", "/", "delete"},
+	}
+	for _, c := range cases {
+		if got := operationOf(c.method, c.path); got != c.want {
+			t.Errorf("%s %s: expected %s, got %s", c.method, c.path, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package hypercloud
+
+import (
+	"net"
+	"sort"
+)
+
+// A flattened view of the account for monitoring and configuration tools: every
+// resource with its region code and tier name resolved and instance addresses
+// looked up, from one pass over the list endpoints.
+
+type InventoryInstance struct {
+	Id         string
+	Name       string
+	State      string
+	Region     string
+	RegionCode string
+	Tier       string
+	TierName   string
+	MemoryMB   float64
+	PublicIPs  []string
+	PrivateIPs []string
+	Context    map[string]string
+}
+
+type InventoryDisk struct {
+	Id         string
+	Name       string
+	State      string
+	Region     string
+	RegionCode string
+	Tier       string
+	TierName   string
+	SizeGB     float64
+}
+
+type InventoryIP struct {
+	Id         string
+	Address    string
+	Type       string //public or private
+	Region     string
+	RegionCode string
+	Instance   string //empty when not attached to anything
+}
+
+type InventoryNetwork struct {
+	Id         string
+	Name       string
+	Region     string
+	RegionCode string
+}
+
+type Inventory struct {
+	Instances     []InventoryInstance
+	Disks         []InventoryDisk
+	IPs           []InventoryIP
+	Networks      []InventoryNetwork
+	InstanceTiers []PerformanceTier
+	DiskTiers     []PerformanceTier
+}
+
+// Lists everything on the account. Any listing failing fails the whole inventory,
+// a partial one would look like resources had gone away.
+func (h *hypercloud) Inventory() (inv Inventory, err []error) {
+	regions, err := h.regionCodes()
+	if err != nil {
+		return
+	}
+	region := func(data interface{}) (id string, code string) {
+		r := mapOf(data)["region"]
+		id = idOf(r)
+		if code = stringOf(r, "code"); code == "" {
+			code = regions[id]
+		}
+		return
+	}
+
+	instanceTiers, err := h.PerformanceTierListInstance()
+	if err != nil {
+		return
+	}
+	diskTiers, err := h.PerformanceTierListDisk()
+	if err != nil {
+		return
+	}
+	inv.InstanceTiers = PerformanceTiers(instanceTiers)
+	inv.DiskTiers = PerformanceTiers(diskTiers)
+	tierNames := make(map[string]string)
+	for _, t := range append(append([]PerformanceTier(nil), inv.InstanceTiers...), inv.DiskTiers...) {
+		tierNames[t.Id] = t.Name
+	}
+	tier := func(data interface{}) (id string, name string) {
+		t := mapOf(data)["performance_tier"]
+		id = idOf(t)
+		if name = stringOf(t, "name"); name == "" {
+			name = tierNames[id]
+		}
+		return
+	}
+
+	ips, err := h.IPAddressList()
+	if err != nil {
+		return
+	}
+	byId := make(map[string]int)
+	for _, i := range sliceOf(ips) {
+		ip := InventoryIP{
+			Id:       idOf(i),
+			Address:  stringOf(i, "address"),
+			Type:     stringOf(i, "type"),
+			Instance: idOf(mapOf(i)["instance"]),
+		}
+		ip.Region, ip.RegionCode = region(i)
+		ip.Type = ipType(ip.Address, ip.Type)
+		byId[ip.Id] = len(inv.IPs)
+		inv.IPs = append(inv.IPs, ip)
+	}
+
+	instances, err := h.InstanceList()
+	if err != nil {
+		return
+	}
+	for _, i := range sliceOf(instances) {
+		inst := InventoryInstance{
+			Id:       idOf(i),
+			Name:     stringOf(i, "name"),
+			State:    stringOf(i, "state"),
+			MemoryMB: numberOf(i, "memory"),
+			Context:  contextOf(i),
+		}
+		inst.Region, inst.RegionCode = region(i)
+		inst.Tier, inst.TierName = tier(i)
+		for _, a := range sliceOf(mapOf(i)["network_adapters"]) {
+			for _, entry := range sliceOf(mapOf(a)["ip_addresses"]) {
+				ip := InventoryIP{Id: idOf(entry), Address: stringOf(entry, "address"), Type: stringOf(entry, "type")}
+				if n, ok := byId[ip.Id]; ok {
+					// Adapters are the authority on what's attached where
+					inv.IPs[n].Instance = inst.Id
+					ip = inv.IPs[n]
+				}
+				if ip.Address == "" {
+					continue
+				}
+				if ipType(ip.Address, ip.Type) == "public" {
+					inst.PublicIPs = append(inst.PublicIPs, ip.Address)
+				} else {
+					inst.PrivateIPs = append(inst.PrivateIPs, ip.Address)
+				}
+			}
+		}
+		inv.Instances = append(inv.Instances, inst)
+	}
+	sort.Slice(inv.Instances, func(a, b int) bool {
+		if inv.Instances[a].Name != inv.Instances[b].Name {
+			return inv.Instances[a].Name < inv.Instances[b].Name
+		}
+		return inv.Instances[a].Id < inv.Instances[b].Id
+	})
+
+	disks, err := h.DiskList()
+	if err != nil {
+		return
+	}
+	for _, d := range sliceOf(disks) {
+		disk := InventoryDisk{
+			Id:     idOf(d),
+			Name:   stringOf(d, "name"),
+			State:  stringOf(d, "state"),
+			SizeGB: numberOf(d, "size"),
+		}
+		disk.Region, disk.RegionCode = region(d)
+		disk.Tier, disk.TierName = tier(d)
+		inv.Disks = append(inv.Disks, disk)
+	}
+
+	networks, err := h.NetworkList()
+	if err != nil {
+		return
+	}
+	for _, n := range sliceOf(networks) {
+		network := InventoryNetwork{Id: idOf(n), Name: stringOf(n, "name")}
+		network.Region, network.RegionCode = region(n)
+		inv.Networks = append(inv.Networks, network)
+	}
+	return
+}
+
+// The type the API gave, or a guess from the address when it didn't give one
+func ipType(address string, given string) string {
+	parsed := net.ParseIP(address)
+	if given != "" || parsed == nil {
+		return given
+	}
+	if parsed.IsPrivate() || parsed.IsLoopback() {
+		return "private"
+	}
+	return "public"
+}
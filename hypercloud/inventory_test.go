@@ -0,0 +1,58 @@
+package hypercloud
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInventory(t *testing.T) {
+	cloud := newFakeCloud()
+	cloud.lists["/regions"] = []interface{}{map[string]interface{}{"id": "region-1", "code": "SY3"}}
+	cloud.lists["/performance_tiers/instances"] = []interface{}{map[string]interface{}{"id": "tier-1", "name": "Standard"}}
+	cloud.lists["/performance_tiers/disks"] = []interface{}{map[string]interface{}{"id": "tier-2", "name": "SSD"}}
+	cloud.lists["/networks"] = []interface{}{map[string]interface{}{"id": "net-1", "name": "private", "region": "region-1"}}
+	public := cloud.addIP("203.0.113.5", "net-public")
+	private := cloud.addIP("10.0.0.5", "net-private")
+	spare := cloud.addIP("203.0.113.6", "net-public")
+	listed := cloud.addIP("10.0.0.9", "net-private")
+	web := cloud.addInstance("web", InstanceStateRunning)
+	cloud.instances[web]["region"] = "region-1"
+	cloud.instances[web]["performance_tier"] = "tier-1"
+	cloud.instances[web]["network_adapters"] = []interface{}{
+		map[string]interface{}{"network": "net-public", "ip_addresses": []interface{}{public}},
+		// Adapters may carry the address itself, or name an IP the API doesn't list
+		map[string]interface{}{"network": "net-private", "ip_addresses": []interface{}{
+			private,
+			map[string]interface{}{"id": "ip-unlisted", "address": "10.0.0.7"},
+		}},
+	}
+	// The IP claims an instance but isn't on its adapters, it's still counted as in use
+	cloud.ips[listed]["instance"] = "instance-elsewhere"
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	inv, err := hc.Inventory()
+	if err != nil {
+		t.Fatalf("Inventory failed: %v", err)
+	}
+	if len(inv.Instances) != 1 {
+		t.Fatalf("Expected 1 instance, got %+v", inv.Instances)
+	}
+	i := inv.Instances[0]
+	if i.RegionCode != "SY3" || i.TierName != "Standard" {
+		t.Errorf("Expected region and tier names to be resolved, got %+v", i)
+	}
+	if !reflect.DeepEqual(i.PublicIPs, []string{"203.0.113.5"}) || !reflect.DeepEqual(i.PrivateIPs, []string{"10.0.0.5", "10.0.0.7"}) {
+		t.Errorf("Unexpected addresses public %v private %v", i.PublicIPs, i.PrivateIPs)
+	}
+	holders := make(map[string]string)
+	for _, ip := range inv.IPs {
+		holders[ip.Id] = ip.Instance
+	}
+	want := map[string]string{public: web, private: web, spare: "", listed: "instance-elsewhere"}
+	if !reflect.DeepEqual(holders, want) {
+		t.Errorf("Expected IPs to be matched to the adapters, got %v", holders)
+	}
+	if len(inv.Networks) != 1 || inv.Networks[0].RegionCode != "SY3" {
+		t.Errorf("Unexpected networks %+v", inv.Networks)
+	}
+}
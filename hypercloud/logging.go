@@ -0,0 +1,101 @@
+package hypercloud
+
+import (
+	"context"
+	Json "encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Optional structured logging of every request through log/slog. Successful
+// calls are logged at debug level, failed ones at warn with the response body.
+// The token never appears in the output: the Authorization header is redacted
+// and any copy of the token echoed back in a body is masked.
+
+// Headers whose values are replaced with "[redacted]" when logged
+var LogRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Auth-Token"}
+
+// Response headers checked, in order, for the request id the API assigned
+var requestIdHeaders = []string{"X-Request-Id", "X-Correlation-Id", "X-Amzn-Trace-Id"}
+
+// Logs requests to l, or stops logging if l is nil. Use a handler with its level
+// at slog.LevelDebug to see successful calls too.
+func (h *hypercloud) SetLogger(l *slog.Logger) {
+	h.logger = l
+}
+
+func (h *hypercloud) logRequest(method string, url string, status int, json interface{}, body string, header http.Header, took time.Duration) {
+	attrs := []any{
+		"method", method,
+		"path", url,
+		"status", status,
+		"latency", took,
+	}
+	if id := requestId(header); id != "" {
+		attrs = append(attrs, "request_id", id)
+	}
+	if 200 <= status && status < 300 {
+		if h.logger.Enabled(context.Background(), slog.LevelDebug) {
+			attrs = append(attrs, "headers", redactHeaders(h.requestHeaders()))
+		}
+		h.logger.Debug("hypercloud request", attrs...)
+		return
+	}
+	// body is only set when the request didn't get a decodable response, the
+	// API's own errors come back as json
+	if body == "" && json != nil {
+		if encoded, err := Json.Marshal(redact(json)); err == nil {
+			body = string(encoded)
+		}
+	}
+	if h.token != "" {
+		body = strings.ReplaceAll(body, h.token, "[redacted]")
+	}
+	h.logger.Warn("hypercloud request failed", append(attrs, "error", truncate(body, 1024))...)
+}
+
+// At most max bytes of s, cut back to the start of a character so a multi-byte
+// one isn't split
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max] + "..."
+}
+
+func requestId(header http.Header) string {
+	for _, k := range requestIdHeaders {
+		if v := header.Get(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// A copy of header with sensitive values replaced, as a slog group
+func redactHeaders(header http.Header) slog.Value {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, k := range keys {
+		value := strings.Join(header[k], ", ")
+		for _, r := range LogRedactHeaders {
+			if strings.EqualFold(k, r) {
+				value = "[redacted]"
+				break
+			}
+		}
+		attrs = append(attrs, slog.String(k, value))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,52 @@
+package hypercloud
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLoggingRedacts(t *testing.T) {
+	const token = "s3cret-token-value"
+	hc := newTestHypercloud(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-42")
+		if r.Method == "POST" {
+			// An API echoing the credentials back must not leak them into the log
+			writeJson(w, 422, map[string]interface{}{"error": "bad token " + token, "password": "hunter2"})
+			return
+		}
+		writeJson(w, 200, map[string]interface{}{"id": "i-1"})
+	})
+	hc.token = token
+	var out bytes.Buffer
+	hc.SetLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	hc.InstanceInfo("i-1")
+	hc.InstanceAssemble(map[string]interface{}{"name": "web"})
+
+	log := out.String()
+	for _, leak := range []string{token, "Bearer", "hunter2"} {
+		if strings.Contains(log, leak) {
+			t.Errorf("Log contains %q:\n%s", leak, log)
+		}
+	}
+	for _, want := range []string{"headers.Authorization=[redacted]", "request_id=req-42", "level=WARN", "status=422", "bad token [redacted]"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("Expected the log to contain %q:\n%s", want, log)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	long := "x" + strings.Repeat("é", 600) //1201 bytes, byte 1024 is the middle of an é
+	got := truncate(long, 1024)
+	if !utf8.ValidString(got) || len(got) != 1023+len("...") || !strings.HasSuffix(got, "é...") {
+		t.Errorf("Expected a cut before the split character, got %d bytes ending %q", len(got), got[len(got)-8:])
+	}
+	if got := truncate("short", 1024); got != "short" {
+		t.Errorf("Expected a short body to be left alone, got %q", got)
+	}
+}
@@ -0,0 +1,196 @@
+package hypercloud
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Private networks are created from a CIDR "specification" (e.g. 10.6.9.0/24).
+// These helpers check a specification before it is sent, carve free subnets
+// out of a larger range and work out which addresses in a network can be handed
+// to IPAddressCreate.
+
+var privateRanges = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+
+// Parses and checks a network specification: IPv4, no host bits set and inside
+// one of the RFC 1918 private ranges.
+func ParseNetworkSpecification(spec string) (*net.IPNet, error) {
+	ip, ipnet, err := net.ParseCIDR(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid network specification %q: %v", spec, err)
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("Invalid network specification %q: only IPv4 networks are supported", spec)
+	}
+	if !ip.Equal(ipnet.IP) {
+		return nil, fmt.Errorf("Invalid network specification %q: host bits set, did you mean %s?", spec, ipnet)
+	}
+	if ones, _ := ipnet.Mask.Size(); ones > 30 {
+		return nil, fmt.Errorf("Invalid network specification %q: a /%d has no usable addresses", spec, ones)
+	}
+	for _, r := range privateRanges {
+		_, private, _ := net.ParseCIDR(r)
+		if contains(private, ipnet) {
+			return ipnet, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid network specification %q: not inside a private range %v", spec, privateRanges)
+}
+
+func NetworksOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Whether inner is entirely inside outer
+func contains(outer *net.IPNet, inner *net.IPNet) bool {
+	outerOnes, _ := outer.Mask.Size()
+	innerOnes, _ := inner.Mask.Size()
+	return outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+func ipToUint(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uintToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// Finds the lowest /prefixLen inside supernet that overlaps none of the existing
+// specifications. Existing entries which don't parse are ignored.
+func PlanSubnet(supernet string, prefixLen int, existing []string) (string, error) {
+	super, err := ParseNetworkSpecification(supernet)
+	if err != nil {
+		return "", err
+	}
+	superOnes, _ := super.Mask.Size()
+	if prefixLen < superOnes || prefixLen > 30 {
+		return "", fmt.Errorf("Can't fit a /%d inside %s", prefixLen, supernet)
+	}
+	var taken []*net.IPNet
+	for _, spec := range existing {
+		if _, ipnet, err := net.ParseCIDR(spec); err == nil {
+			taken = append(taken, ipnet)
+		}
+	}
+	size := uint32(1) << uint(32-prefixLen)
+	start := ipToUint(super.IP)
+	end := uint64(start) + uint64(1)<<uint(32-superOnes)
+	for candidate := uint64(start); candidate+uint64(size) <= end; candidate += uint64(size) {
+		subnet := &net.IPNet{IP: uintToIP(uint32(candidate)), Mask: net.CIDRMask(prefixLen, 32)}
+		free := true
+		for _, t := range taken {
+			if NetworksOverlap(subnet, t) {
+				free = false
+				// Skip straight past the taken network if it is the bigger one
+				if tEnd := uint64(ipToUint(t.IP)) + uint64(1)<<uint(32-onesOf(t)); tEnd > candidate+uint64(size) {
+					candidate = tEnd - uint64(size)
+				}
+				break
+			}
+		}
+		if free {
+			return subnet.String(), nil
+		}
+	}
+	return "", fmt.Errorf("No free /%d left in %s", prefixLen, supernet)
+}
+
+func onesOf(ipnet *net.IPNet) int {
+	ones, _ := ipnet.Mask.Size()
+	return ones
+}
+
+// The addresses in a network that can be given to instances. The network and
+// broadcast addresses are excluded, as is the first host which is the gateway.
+func UsableHostRange(spec string) (first net.IP, last net.IP, count int, err error) {
+	ipnet, err := ParseNetworkSpecification(spec)
+	if err != nil {
+		return
+	}
+	start := ipToUint(ipnet.IP)
+	size := uint32(1) << uint(32-onesOf(ipnet))
+	first = uintToIP(start + 2)
+	last = uintToIP(start + size - 2)
+	count = int(size) - 3
+	return
+}
+
+// The specifications of the account's private networks
+func (h *hypercloud) privateSpecifications() (specs []string, err []error) {
+	networks, err := h.NetworkListPrivate()
+	if err != nil {
+		return
+	}
+	for _, n := range sliceOf(networks) {
+		if spec := stringOf(n, "specification"); spec != "" {
+			specs = append(specs, spec)
+		}
+	}
+	return
+}
+
+// The lowest free /prefixLen in supernet, given the account's existing private networks
+func (h *hypercloud) NetworkPlan(supernet string, prefixLen int) (spec string, err []error) {
+	existing, err := h.privateSpecifications()
+	if err != nil {
+		return
+	}
+	spec, erro := PlanSubnet(supernet, prefixLen, existing)
+	if erro != nil {
+		err = append(err, erro)
+	}
+	return
+}
+
+// NetworkCreate, but the specification is checked and refused if it overlaps
+// one of the account's existing private networks.
+func (h *hypercloud) NetworkCreateChecked(body interface{}) (json interface{}, err []error) {
+	spec := stringOf(body, "specification")
+	ipnet, erro := ParseNetworkSpecification(spec)
+	if erro != nil {
+		return nil, []error{erro}
+	}
+	existing, err := h.privateSpecifications()
+	if err != nil {
+		return
+	}
+	for _, e := range existing {
+		if _, other, erro := net.ParseCIDR(e); erro == nil && NetworksOverlap(ipnet, other) {
+			return nil, []error{fmt.Errorf("Network specification %s overlaps existing network %s", spec, e)}
+		}
+	}
+	return h.NetworkCreate(body)
+}
+
+// The lowest usable address in a network not already taken by one of its IP addresses
+func (h *hypercloud) NetworkNextFreeAddress(netId string) (address string, err []error) {
+	network, err := h.NetworkInfo(netId)
+	if err != nil {
+		return
+	}
+	first, last, _, erro := UsableHostRange(stringOf(network, "specification"))
+	if erro != nil {
+		return "", []error{erro}
+	}
+	ips, err := h.IPAddressListPrivate()
+	if err != nil {
+		return
+	}
+	used := make(map[string]bool)
+	for _, ip := range sliceOf(ips) {
+		if stringOf(ip, "network_id") == netId || idOf(mapOf(ip)["network"]) == netId {
+			used[stringOf(ip, "address")] = true
+		}
+	}
+	for n := ipToUint(first); n <= ipToUint(last); n++ {
+		if a := uintToIP(n).String(); !used[a] {
+			return a, nil
+		}
+	}
+	err = append(err, fmt.Errorf("Network %s has no free addresses left", netId))
+	return
+}
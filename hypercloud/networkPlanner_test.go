@@ -0,0 +1,122 @@
+package hypercloud
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNetworkSpecification(t *testing.T) {
+	valid := []string{"10.6.9.0/24", "172.16.0.0/12", "192.168.100.0/30"}
+	for _, spec := range valid {
+		if _, err := ParseNetworkSpecification(spec); err != nil {
+			t.Errorf("Expected %s to be valid: %v", spec, err)
+		}
+	}
+	invalid := []string{"10.6.9.1/24", "8.8.8.0/24", "10.0.0.0/31", "fd00::/64", "10.6.9.0", "172.15.0.0/16"}
+	for _, spec := range invalid {
+		if _, err := ParseNetworkSpecification(spec); err == nil {
+			t.Errorf("Expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestPlanSubnet(t *testing.T) {
+	cases := []struct {
+		supernet string
+		prefix   int
+		existing []string
+		want     string
+	}{
+		{"10.6.0.0/16", 24, nil, "10.6.0.0/24"},
+		{"10.6.0.0/16", 24, []string{"10.6.0.0/24", "10.6.1.128/25"}, "10.6.2.0/24"},
+		{"10.6.0.0/16", 24, []string{"10.6.0.0/22", "192.168.0.0/24"}, "10.6.4.0/24"},
+		{"10.6.0.0/16", 26, []string{"10.6.0.0/26", "10.6.0.128/26"}, "10.6.0.64/26"},
+		{"10.0.0.0/8", 16, []string{"10.0.0.0/9"}, "10.128.0.0/16"},
+	}
+	for _, c := range cases {
+		got, err := PlanSubnet(c.supernet, c.prefix, c.existing)
+		if err != nil || got != c.want {
+			t.Errorf("PlanSubnet(%s, %d, %v) = %s, %v; want %s", c.supernet, c.prefix, c.existing, got, err, c.want)
+		}
+	}
+
+	if _, err := PlanSubnet("10.6.9.0/24", 24, []string{"10.6.0.0/16"}); err == nil {
+		t.Errorf("Expected planning inside a fully used supernet to fail")
+	}
+	if _, err := PlanSubnet("10.6.9.0/24", 16, nil); err == nil {
+		t.Errorf("Expected a prefix larger than the supernet to fail")
+	}
+}
+
+func TestUsableHostRange(t *testing.T) {
+	first, last, count, err := UsableHostRange("10.6.9.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != "10.6.9.2" || last.String() != "10.6.9.254" || count != 253 {
+		t.Errorf("Unexpected range %s - %s (%d)", first, last, count)
+	}
+}
+
+func TestNetworkPlan(t *testing.T) {
+	cloud := newFakeCloud()
+	cloud.addNetwork("10.0.0.0/24")
+	cloud.addNetwork("10.0.1.0/24")
+	cloud.addNetwork("192.168.0.0/16")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	if spec, err := hc.NetworkPlan("10.0.0.0/16", 24); err != nil || spec != "10.0.2.0/24" {
+		t.Errorf("Expected 10.0.2.0/24, got %q %v", spec, err)
+	}
+	if spec, err := hc.NetworkPlan("192.168.0.0/16", 24); err == nil {
+		t.Errorf("Expected no room in a range that's already taken, got %s", spec)
+	}
+}
+
+func TestNetworkCreateChecked(t *testing.T) {
+	cloud := newFakeCloud()
+	cloud.addNetwork("10.0.0.0/24")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	refused := map[string]string{
+		"10.0.0.128/25": "overlaps existing network 10.0.0.0/24",
+		"10.0.0.0/16":   "overlaps existing network 10.0.0.0/24",
+		"8.8.8.0/24":    "private",
+		"10.0.1.1/24":   "host bits",
+	}
+	for spec, want := range refused {
+		if _, err := hc.NetworkCreateChecked(map[string]interface{}{"name": "net", "specification": spec}); err == nil || !strings.Contains(err[0].Error(), want) {
+			t.Errorf("%s: expected an error about %q, got %v", spec, want, err)
+		}
+	}
+	if len(cloud.networks) != 1 {
+		t.Fatalf("Expected refused networks not to be created, have %d", len(cloud.networks))
+	}
+
+	created, err := hc.NetworkCreateChecked(map[string]interface{}{"name": "net", "specification": "10.0.1.0/24"})
+	if err != nil || stringOf(created, "specification") != "10.0.1.0/24" {
+		t.Errorf("Expected the network to be created, got %v %v", created, err)
+	}
+}
+
+func TestNetworkNextFreeAddress(t *testing.T) {
+	cloud := newFakeCloud()
+	netId := cloud.addNetwork("10.0.0.0/29") //.2 to .6 are usable
+	other := cloud.addNetwork("10.0.1.0/29")
+	cloud.addIP("10.0.0.2", netId)
+	cloud.addIP("10.0.0.3", other) //same address, different network
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	if address, err := hc.NetworkNextFreeAddress(netId); err != nil || address != "10.0.0.3" {
+		t.Errorf("Expected 10.0.0.3, got %q %v", address, err)
+	}
+	for _, a := range []string{"10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"} {
+		cloud.addIP(a, netId)
+	}
+	if address, err := hc.NetworkNextFreeAddress(netId); err == nil || !strings.Contains(err[0].Error(), "no free addresses") {
+		t.Errorf("Expected a full network to be reported, got %q %v", address, err)
+	}
+	if _, err := hc.NetworkNextFreeAddress("net-missing"); err == nil {
+		t.Errorf("Expected an unknown network to be an error")
+	}
+}
@@ -0,0 +1,102 @@
+// Package otelhc reports the HyperCloud client's requests and workflows to
+// OpenTelemetry: a span per HTTP call and per multi-step helper, plus request
+// count, latency and error metrics.
+//
+//	hc.SetInstrumentation(otelhc.New(nil, nil))
+//
+// Exporters are whatever the tracer and meter providers are configured with,
+// nil providers use the global ones.
+package otelhc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+
+type Instrumentation struct {
+	tracer   trace.Tracer
+	requests metric.Int64Counter
+	errors   metric.Int64Counter
+	latency  metric.Float64Histogram
+}
+
+// Satisfies hypercloud.Instrumentation
+var _ hypercloud.Instrumentation = (*Instrumentation)(nil)
+
+func New(tp trace.TracerProvider, mp metric.MeterProvider) *Instrumentation {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+	i := &Instrumentation{tracer: tp.Tracer(instrumentationName)}
+	// Instrument creation only fails on invalid names, and returns a no-op
+	// instrument alongside the error, so it's safe to ignore
+	i.requests, _ = meter.Int64Counter("hypercloud.client.requests",
+		metric.WithDescription("Requests made to the HyperCloud API"))
+	i.errors, _ = meter.Int64Counter("hypercloud.client.errors",
+		metric.WithDescription("Failed requests to the HyperCloud API, by error class"))
+	i.latency, _ = meter.Float64Histogram("hypercloud.client.request.duration",
+		metric.WithDescription("Duration of requests to the HyperCloud API"),
+		metric.WithUnit("s"))
+	return i
+}
+
+func (i *Instrumentation) StartRequest(ctx context.Context, info hypercloud.RequestInfo) func(hypercloud.RequestOutcome) {
+	common := []attribute.KeyValue{
+		attribute.String("http.request.method", info.Method),
+		attribute.String("hypercloud.resource.type", info.ResourceType),
+		attribute.String("hypercloud.operation", info.Operation),
+	}
+	ctx, span := i.tracer.Start(ctx, "hypercloud "+info.ResourceType+"."+info.Operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(common...),
+		trace.WithAttributes(attribute.String("url.path", info.Path)))
+	return func(out hypercloud.RequestOutcome) {
+		status := attribute.Int("http.response.status_code", out.Status)
+		span.SetAttributes(status, attribute.Int("hypercloud.attempt", out.Attempt))
+		if out.ErrorClass != "" {
+			span.SetAttributes(attribute.String("error.type", out.ErrorClass))
+			span.SetStatus(codes.Error, out.ErrorClass)
+		}
+		span.End()
+
+		attrs := append(common, status)
+		set := metric.WithAttributes(attrs...)
+		i.requests.Add(ctx, 1, set)
+		i.latency.Record(ctx, out.Duration.Seconds(), set)
+		if out.ErrorClass != "" {
+			i.errors.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("error.type", out.ErrorClass))...))
+		}
+	}
+}
+
+func (i *Instrumentation) StartWorkflow(ctx context.Context, name string, attrs map[string]string) (context.Context, func(err []error)) {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kvs = append(kvs, attribute.String("hypercloud."+k, v))
+	}
+	ctx, span := i.tracer.Start(ctx, "hypercloud "+name, trace.WithAttributes(kvs...))
+	return ctx, func(err []error) {
+		if len(err) > 0 {
+			msgs := make([]string, len(err))
+			for n, e := range err {
+				msgs[n] = e.Error()
+				span.RecordError(e)
+			}
+			span.SetStatus(codes.Error, strings.Join(msgs, "; "))
+		}
+		span.End()
+	}
+}
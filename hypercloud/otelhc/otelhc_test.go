@@ -0,0 +1,101 @@
+package otelhc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInstanceUpdateSpans(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "PUT" && strings.HasSuffix(r.URL.Path, "/instances/i-1") {
+			w.WriteHeader(422)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "name is taken"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": "i-1", "name": "web"})
+	}))
+	defer srv.Close()
+
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	hc, _ := hypercloud.NewHypercloud(srv.URL, "test-token")
+	hc.SetInstrumentation(New(tp, mp))
+	hc.InstanceUpdate("i-1", map[string]interface{}{
+		"disks":       []interface{}{"d-1"},
+		"public_keys": []interface{}{"k-1"},
+		"name":        "web",
+	})
+
+	ended := spans.GetSpans()
+	if len(ended) != 4 {
+		t.Fatalf("expected 3 request spans and a workflow span, got %d", len(ended))
+	}
+	workflow := ended[len(ended)-1]
+	if workflow.Name != "hypercloud InstanceUpdate" {
+		t.Fatalf("expected the workflow to end last, got %s", workflow.Name)
+	}
+	if workflow.Status.Code.String() != "Error" {
+		t.Errorf("expected the workflow to carry the failed request's error, got %v", workflow.Status)
+	}
+	names := []string{}
+	for _, s := range ended[:3] {
+		names = append(names, s.Name)
+		if s.Parent.SpanID() != workflow.SpanContext.SpanID() {
+			t.Errorf("%s is not a child of the workflow", s.Name)
+		}
+	}
+	if strings.Join(names, ",") != "hypercloud instances.disks,hypercloud instances.public_keys,hypercloud instances.update" {
+		t.Errorf("unexpected request spans %v", names)
+	}
+	class := ""
+	for _, kv := range ended[2].Attributes {
+		if kv.Key == "error.type" {
+			class = kv.Value.AsString()
+		}
+	}
+	if class != "validation" {
+		t.Errorf("expected the update to fail validation, got %q", class)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	sums := map[string]int64{}
+	var latencies uint64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					sums[m.Name] += dp.Value
+					if class, ok := dp.Attributes.Value(attribute.Key("error.type")); ok && class.AsString() != "validation" {
+						t.Errorf("unexpected error class %s", class.AsString())
+					}
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					latencies += dp.Count
+				}
+			}
+		}
+	}
+	if sums["hypercloud.client.requests"] != 3 || sums["hypercloud.client.errors"] != 1 || latencies != 3 {
+		t.Errorf("unexpected metrics: %v, %d latencies", sums, latencies)
+	}
+}
@@ -0,0 +1,202 @@
+package hypercloud
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A performance tier with the figures we know how to read out of the API.
+// Figures the API doesn't expose are left at 0.
+type PerformanceTier struct {
+	Id         string
+	Name       string
+	Region     string
+	RegionCode string
+	CPU        float64 //cores
+	Memory     float64 //MB
+	IOPS       float64
+	Throughput float64 //MB/s
+	Price      float64 //hourly, per PriceUnit
+	PriceUnit  string  //as the API reports it, e.g. "gb_hour" or "hour", empty if it doesn't
+	Currency   string  //of Price, empty if the API doesn't say
+	Raw        interface{}
+}
+
+// What a tier has to offer to be picked. Zero values are not checked.
+// Tiers which don't report a figure that is asked for are skipped.
+type TierRequirements struct {
+	Region        string //id or code
+	Name          string //exact (case insensitive) tier name
+	MinCPU        float64
+	MinMemory     float64
+	MinIOPS       float64
+	MinThroughput float64
+	MaxPrice      float64
+	PriceUnit     string //the unit MaxPrice is in, tiers priced in another unit don't qualify
+}
+
+func firstNumber(data interface{}, keys ...string) float64 {
+	for _, k := range keys {
+		if v := numberOf(data, k); v != 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+func firstString(data interface{}, keys ...string) string {
+	for _, k := range keys {
+		if v := stringOf(data, k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// The canonical name of a price unit, "gb_hour" or "hour" for the spellings we
+// know, otherwise the unit as given in lower case
+func priceUnit(unit string) string {
+	u := strings.ToLower(strings.NewReplacer("-", "_", " ", "_", "/", "_").Replace(unit))
+	switch u {
+	case "gb_hour", "gb_hr", "per_gb_hour", "gb":
+		return "gb_hour"
+	case "hour", "hr", "per_hour":
+		return "hour"
+	}
+	return u
+}
+
+func parsePerformanceTier(t interface{}) PerformanceTier {
+	region := mapOf(t)["region"]
+	return PerformanceTier{
+		Id:         idOf(t),
+		Name:       stringOf(t, "name"),
+		Region:     idOf(region),
+		RegionCode: stringOf(region, "code"),
+		CPU:        firstNumber(t, "cpu", "vcpus", "cores"),
+		Memory:     firstNumber(t, "memory", "max_memory"),
+		IOPS:       firstNumber(t, "iops", "max_iops"),
+		Throughput: firstNumber(t, "throughput", "max_throughput"),
+		Price:      firstNumber(t, "price", "hourly_price", "price_per_hour"),
+		PriceUnit:  firstString(t, "price_unit", "unit"),
+		Currency:   firstString(t, "currency", "price_currency"),
+		Raw:        t,
+	}
+}
+
+func (r TierRequirements) satisfiedBy(t PerformanceTier) bool {
+	if r.Region != "" && t.Region != r.Region && !strings.EqualFold(t.RegionCode, r.Region) {
+		return false
+	}
+	if r.Name != "" && !strings.EqualFold(t.Name, r.Name) {
+		return false
+	}
+	atLeast := func(have float64, want float64) bool { return want == 0 || have >= want }
+	if !atLeast(t.CPU, r.MinCPU) || !atLeast(t.Memory, r.MinMemory) || !atLeast(t.IOPS, r.MinIOPS) || !atLeast(t.Throughput, r.MinThroughput) {
+		return false
+	}
+	if r.MaxPrice != 0 && (t.Price == 0 || t.Price > r.MaxPrice || priceUnit(t.PriceUnit) != priceUnit(r.PriceUnit)) {
+		return false
+	}
+	return true
+}
+
+// Cheapest first (unknown prices last), then the most capable going by CPU,
+// memory, IOPS and throughput in that order, then by name and id. Prices are
+// only compared within a unit, tiers priced in different units are grouped by
+// unit name before their price.
+func sortTiers(tiers []PerformanceTier) {
+	sort.SliceStable(tiers, func(i, j int) bool {
+		a, b := tiers[i], tiers[j]
+		if (a.Price == 0) != (b.Price == 0) {
+			return b.Price == 0
+		}
+		if ua, ub := priceUnit(a.PriceUnit), priceUnit(b.PriceUnit); a.Price != 0 && ua != ub {
+			return ua < ub
+		}
+		if a.Price != b.Price {
+			return a.Price < b.Price
+		}
+		for _, f := range []struct{ a, b float64 }{{a.CPU, b.CPU}, {a.Memory, b.Memory}, {a.IOPS, b.IOPS}, {a.Throughput, b.Throughput}} {
+			if f.a != f.b {
+				return f.a > f.b
+			}
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Id < b.Id
+	})
+}
+
+func selectTier(list interface{}, req TierRequirements) (best PerformanceTier, candidates []PerformanceTier, err []error) {
+	for _, t := range sliceOf(list) {
+		tier := parsePerformanceTier(t)
+		if req.satisfiedBy(tier) {
+			candidates = append(candidates, tier)
+		}
+	}
+	if len(candidates) == 0 {
+		err = append(err, fmt.Errorf("No performance tier satisfies %+v", req))
+		return
+	}
+	sortTiers(candidates)
+	best = candidates[0]
+	return
+}
+
+// The best instance tier for the requirements, and every tier that qualified (best first)
+func (h *hypercloud) PerformanceTierSelectInstance(req TierRequirements) (best PerformanceTier, candidates []PerformanceTier, err []error) {
+	list, err := h.PerformanceTierListInstance()
+	if err != nil {
+		return
+	}
+	return selectTier(list, req)
+}
+
+// The best disk tier for the requirements, and every tier that qualified (best first)
+func (h *hypercloud) PerformanceTierSelectDisk(req TierRequirements) (best PerformanceTier, candidates []PerformanceTier, err []error) {
+	list, err := h.PerformanceTierListDisk()
+	if err != nil {
+		return
+	}
+	return selectTier(list, req)
+}
+
+// Every tier from one of the PerformanceTierList calls, parsed and sorted
+func PerformanceTiers(list interface{}) (tiers []PerformanceTier) {
+	for _, t := range sliceOf(list) {
+		tiers = append(tiers, parsePerformanceTier(t))
+	}
+	sortTiers(tiers)
+	return
+}
+
+// Writes a comparison table of tiers for humans. Figures the API doesn't report
+// show as "-", prices are followed by their unit when the API gives one.
+func PerformanceTierTable(w io.Writer, tiers []PerformanceTier) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tREGION\tCPU\tMEMORY\tIOPS\tTHROUGHPUT\tPRICE\tID")
+	figure := func(v float64) string {
+		if v == 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	for _, t := range tiers {
+		region := t.RegionCode
+		if region == "" {
+			region = t.Region
+		}
+		price := figure(t.Price)
+		if t.Price != 0 && t.PriceUnit != "" {
+			price += "/" + priceUnit(t.PriceUnit)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.Name, region, figure(t.CPU), figure(t.Memory), figure(t.IOPS), figure(t.Throughput), price, t.Id)
+	}
+	return tw.Flush()
+}
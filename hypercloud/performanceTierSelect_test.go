@@ -0,0 +1,117 @@
+package hypercloud
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testTiers = []interface{}{
+	map[string]interface{}{"id": "t-big", "name": "Big", "region": map[string]interface{}{"id": "region-1", "code": "SY3"}, "cpu": 8, "memory": 1024, "price": 0.10},
+	// More memory can't make up for fewer cores
+	map[string]interface{}{"id": "t-wide", "name": "Wide", "region": map[string]interface{}{"id": "region-1", "code": "SY3"}, "cpu": 2, "max_memory": 65536, "price": 0.10},
+	map[string]interface{}{"id": "t-cheap", "name": "Cheap", "region": "region-1", "vcpus": 1, "memory": 512, "hourly_price": 0.01},
+	map[string]interface{}{"id": "t-unknown", "name": "Unknown", "region": "region-1", "cpu": 32, "memory": 131072},
+	map[string]interface{}{"id": "t-me1", "name": "Big", "region": map[string]interface{}{"id": "region-2", "code": "ME1"}, "cpu": 8, "memory": 1024, "price": 0.05},
+}
+
+func TestSelectTier(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        TierRequirements
+		best       string
+		candidates []string
+	}{
+		{"anything", TierRequirements{}, "t-cheap", []string{"t-cheap", "t-me1", "t-big", "t-wide", "t-unknown"}},
+		{"region by code", TierRequirements{Region: "sy3"}, "t-big", []string{"t-big", "t-wide"}},
+		{"region by id", TierRequirements{Region: "region-1"}, "t-cheap", []string{"t-cheap", "t-big", "t-wide", "t-unknown"}},
+		{"cpu", TierRequirements{MinCPU: 2}, "t-me1", []string{"t-me1", "t-big", "t-wide", "t-unknown"}},
+		{"memory", TierRequirements{MinMemory: 2048}, "t-wide", []string{"t-wide", "t-unknown"}},
+		{"max price skips unknown prices", TierRequirements{MinCPU: 4, MaxPrice: 0.2}, "t-me1", []string{"t-me1", "t-big"}},
+		{"name", TierRequirements{Name: "big", Region: "SY3"}, "t-big", []string{"t-big"}},
+		{"figure not reported", TierRequirements{MinIOPS: 1000}, "", nil},
+	}
+	for _, tt := range tests {
+		best, candidates, err := selectTier(testTiers, tt.req)
+		if tt.best == "" {
+			if err == nil {
+				t.Errorf("%s: expected no tier, got %s", tt.name, best.Id)
+			}
+			continue
+		}
+		var ids []string
+		for _, c := range candidates {
+			ids = append(ids, c.Id)
+		}
+		if err != nil || best.Id != tt.best || !reflect.DeepEqual(ids, tt.candidates) {
+			t.Errorf("%s: expected %s of %v, got %s of %v (%v)", tt.name, tt.best, tt.candidates, best.Id, ids, err)
+		}
+	}
+}
+
+func TestSortTiersTies(t *testing.T) {
+	tiers := []PerformanceTier{
+		{Id: "t-4", Name: "B", CPU: 4, Memory: 1024, IOPS: 100},
+		{Id: "t-3", Name: "A", CPU: 4, Memory: 1024, IOPS: 100},
+		{Id: "t-2", Name: "A", CPU: 4, Memory: 1024, IOPS: 100},
+		{Id: "t-1", Name: "Z", CPU: 4, Memory: 1024, IOPS: 500},
+	}
+	sortTiers(tiers)
+	var ids []string
+	for _, tier := range tiers {
+		ids = append(ids, tier.Id)
+	}
+	if want := []string{"t-1", "t-2", "t-3", "t-4"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Expected %v, got %v", want, ids)
+	}
+}
+
+func TestPerformanceTierTable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := PerformanceTierTable(&buf, PerformanceTiers(append(testTiers[2:4:4], unitTiers[2:]...))); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"NAME     REGION    CPU  MEMORY  IOPS  THROUGHPUT  PRICE          ID",
+		"Cheap    region-1  1    512     -     -           0.01           t-cheap",
+		"PerGB    region-1  2    1024    -     -           0.002/gb_hour  t-gb",
+		"Monthly  region-1  2    1024    -     -           20/month       t-monthly",
+		"Unknown  region-1  32   131072  -     -           -              t-unknown",
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected\n%s\ngot\n%s", strings.Join(want, "\n"), buf.String())
+	}
+}
+
+var unitTiers = []interface{}{
+	map[string]interface{}{"id": "t-hourly", "name": "Hourly", "region": "region-1", "cpu": 2, "memory": 1024, "price": 0.05, "price_unit": "per hour"},
+	map[string]interface{}{"id": "t-hr", "name": "Hr", "region": "region-1", "cpu": 2, "memory": 1024, "price": 0.04, "unit": "hr"},
+	map[string]interface{}{"id": "t-gb", "name": "PerGB", "region": "region-1", "cpu": 2, "memory": 1024, "price": 0.002, "price_unit": "GB/hour"},
+	map[string]interface{}{"id": "t-monthly", "name": "Monthly", "region": "region-1", "cpu": 2, "memory": 1024, "price": 20, "price_unit": "month"},
+}
+
+func TestSelectTierPriceUnits(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        TierRequirements
+		candidates []string
+	}{
+		// Grouped by unit, cheapest first within each
+		{"anything", TierRequirements{}, []string{"t-gb", "t-hr", "t-hourly", "t-monthly"}},
+		{"hourly", TierRequirements{MaxPrice: 100, PriceUnit: "hour"}, []string{"t-hr", "t-hourly"}},
+		{"per gb", TierRequirements{MaxPrice: 100, PriceUnit: "gb_hr"}, []string{"t-gb"}},
+		{"unknown unit only matches itself", TierRequirements{MaxPrice: 100, PriceUnit: "Month"}, []string{"t-monthly"}},
+		{"no unit matches unitless prices only", TierRequirements{MaxPrice: 100}, nil},
+	}
+	for _, tt := range tests {
+		_, candidates, _ := selectTier(unitTiers, tt.req)
+		var ids []string
+		for _, c := range candidates {
+			ids = append(ids, c.Id)
+		}
+		if !reflect.DeepEqual(ids, tt.candidates) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.candidates, ids)
+		}
+	}
+}
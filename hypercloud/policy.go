@@ -0,0 +1,236 @@
+package hypercloud
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Guardrails on mutating calls. Every POST/PUT/DELETE going through Request is
+// evaluated against the client's policies first, with the resource it targets
+// fetched so rules can look at its current state. A policy saying no stops the
+// call before anything is sent.
+
+// A mutating call about to be made
+type PolicyRequest struct {
+	Method       string
+	Path         string
+	Body         interface{}
+	ResourceType string      //first path segment, e.g. "disks"
+	ResourceId   string      //empty when creating
+	Target       interface{} //current state of the resource (with its context for instances and disks), nil when creating or if it couldn't be fetched
+}
+
+// Creates a new resource rather than changing an existing one
+func (r *PolicyRequest) IsCreate() bool {
+	return r.ResourceId == ""
+}
+
+type Policy interface {
+	// Returns an error (ideally a *PolicyError) to block the call
+	Evaluate(req *PolicyRequest) error
+}
+
+type PolicyFunc func(req *PolicyRequest) error
+
+func (f PolicyFunc) Evaluate(req *PolicyRequest) error {
+	return f(req)
+}
+
+type PolicyError struct {
+	Rule   string
+	Method string
+	Path   string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("Policy error: %s %s denied by %s: %s", e.Method, e.Path, e.Rule, e.Reason)
+}
+
+// Adds policies, evaluated in order on every mutating request
+func (h *hypercloud) AddPolicy(policies ...Policy) {
+	h.policies = append(h.policies, policies...)
+}
+
+// A copy of the client on which the named rules (PolicyError.Rule) don't block,
+// for the odd deliberate call a policy is there to stop, e.g.
+//
+//	hc.Override("deny-delete-protected").DiskDelete(id)
+//
+// The client itself is left as it was.
+func (h *hypercloud) Override(rules ...string) *hypercloud {
+	c := *h
+	c.overrides = append(append([]string(nil), h.overrides...), rules...)
+	return &c
+}
+
+// Actions which create something rather than act on an id, e.g. POST /instances/assemble
+var createActions = map[string]bool{"assemble": true, "private": true, "public": true}
+
+func parseResourcePath(path string) (resourceType string, resourceId string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	resourceType = parts[0]
+	if len(parts) > 1 && !createActions[parts[1]] {
+		resourceId = parts[1]
+	}
+	return
+}
+
+func (h *hypercloud) checkPolicies(method string, path string, data interface{}) (err []error) {
+	req := &PolicyRequest{Method: method, Path: path, Body: data}
+	req.ResourceType, req.ResourceId = parseResourcePath(path)
+	if !req.IsCreate() {
+		var erro error
+		req.Target, erro = h.policyTarget(req.ResourceType, req.ResourceId)
+		// Rules can't vouch for a change to something they can't see, fail closed
+		if erro != nil && (method == "DELETE" || method == "PUT") {
+			err = append(err, &PolicyError{"target-lookup", method, path, erro.Error()})
+			return
+		}
+	}
+	for _, p := range h.policies {
+		if erro := p.Evaluate(req); erro != nil {
+			if perr, ok := erro.(*PolicyError); ok && indexOf(h.overrides, perr.Rule) >= 0 {
+				continue
+			}
+			err = append(err, erro)
+			return
+		}
+	}
+	return
+}
+
+// Fetched with _request so the lookup isn't itself subject to anything Request does.
+// Instances get their context attached. Disks have no context of their own, they
+// take on the context of the instance they belong to, so a disk of a protected
+// instance is protected too.
+func (h *hypercloud) policyTarget(resourceType string, resourceId string) (target interface{}, err error) {
+	get := func(path string) (interface{}, error) {
+		ret, body, status, _ := h._request("GET", path, nil)
+		if status < 200 || status >= 300 {
+			return nil, fmt.Errorf("Unable to look up %s (%d): %s", path, status, body)
+		}
+		return ret, nil
+	}
+	if target, err = get("/" + resourceType + "/" + resourceId); err != nil {
+		return
+	}
+	m := mapOf(target)
+	if m == nil || m["context"] != nil {
+		return
+	}
+	owner := ""
+	switch resourceType {
+	case "instances":
+		owner = resourceId
+	case "disks":
+		if owner, err = h.policyDiskOwner(m); err != nil {
+			return
+		}
+	}
+	if owner != "" {
+		var ctx interface{}
+		if ctx, err = get("/instances/" + owner + "/context"); err != nil {
+			return
+		}
+		m["context"] = ctx
+	}
+	return
+}
+
+// The instance a disk is attached to, from the disk if it says, otherwise by
+// looking through the instances. Empty for a disk which isn't attached.
+func (h *hypercloud) policyDiskOwner(disk map[string]interface{}) (instanceId string, err error) {
+	if instanceId = idOf(disk["instance"]); instanceId != "" {
+		return
+	}
+	instances, body, status, _ := h._request("GET", "/instances", nil)
+	if status < 200 || status >= 300 {
+		return "", fmt.Errorf("Unable to look up /instances (%d): %s", status, body)
+	}
+	diskId := idOf(disk)
+	for _, i := range sliceOf(instances) {
+		for _, d := range sliceOf(mapOf(i)["disks"]) {
+			if idOf(d) == diskId {
+				return idOf(i), nil
+			}
+		}
+	}
+	return
+}
+
+// Blocks deleting any resource whose context has the key set (to anything but
+// "false"), e.g. DenyDeleteProtected("protected"). Disks are protected through
+// the instance they are attached to. Taking the protection off again (deleting
+// the key, or a context write that drops it or sets it to "false") is blocked
+// too, as "deny-unprotect", so it takes a deliberate Override to get rid of it:
+//
+//	hc.Override("deny-unprotect").InstanceDeleteContextKey(id, "protected")
+//
+// Context writes are checked as if they replace the whole context, so a write
+// to a protected instance has to carry the key along.
+func DenyDeleteProtected(key string) Policy {
+	return PolicyFunc(func(req *PolicyRequest) error {
+		if req.Target == nil {
+			return nil
+		}
+		if v, ok := mapOf(mapOf(req.Target)["context"])[key]; !ok || fmt.Sprintf("%v", v) == "false" {
+			return nil
+		}
+		parts := strings.Split(strings.Trim(req.Path, "/"), "/")
+		if len(parts) >= 3 && parts[2] == "context" {
+			unprotect := false
+			switch req.Method {
+			case "DELETE":
+				unprotect = len(parts) == 3 || parts[3] == key
+			case "POST", "PUT":
+				v, ok := mapOf(req.Body)[key]
+				unprotect = len(parts) == 3 && (!ok || fmt.Sprintf("%v", v) == "false")
+			}
+			if unprotect {
+				return &PolicyError{"deny-unprotect", req.Method, req.Path, fmt.Sprintf("would remove context key %q from %s %s", key, req.ResourceType, req.ResourceId)}
+			}
+			return nil
+		}
+		if req.Method == "DELETE" {
+			return &PolicyError{"deny-delete-protected", req.Method, req.Path, fmt.Sprintf("%s %s has context key %q", req.ResourceType, req.ResourceId, key)}
+		}
+		return nil
+	})
+}
+
+// Only allows requests naming one of the given regions (ids, as used in request
+// bodies). The region is read from the body's top level "region", or for clones
+// without one from the source, which is where the clone ends up. Regions named
+// anywhere else (nested in the body, or implied by other actions such as
+// migrations) are not checked.
+func RestrictRegions(regions ...string) Policy {
+	return PolicyFunc(func(req *PolicyRequest) error {
+		region := idOf(mapOf(req.Body)["region"])
+		if region == "" && strings.HasSuffix(req.Path, "/clone") {
+			region = idOf(mapOf(req.Target)["region"])
+		}
+		if region == "" {
+			return nil
+		}
+		for _, r := range regions {
+			if strings.EqualFold(r, region) {
+				return nil
+			}
+		}
+		return &PolicyError{"restrict-regions", req.Method, req.Path, fmt.Sprintf("region %s is not one of %v", region, regions)}
+	})
+}
+
+// Caps the memory an instance can be created or resized with
+func MaxInstanceMemory(mb float64) Policy {
+	return PolicyFunc(func(req *PolicyRequest) error {
+		if req.ResourceType != "instances" {
+			return nil
+		}
+		if memory := numberOf(req.Body, "memory"); memory > mb {
+			return &PolicyError{"max-instance-memory", req.Method, req.Path, fmt.Sprintf("%g MB requested, at most %g MB allowed", memory, mb)}
+		}
+		return nil
+	})
+}
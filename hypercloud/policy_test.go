@@ -0,0 +1,155 @@
+package hypercloud
+
+import (
+	"testing"
+)
+
+func TestPolicyDenyDeleteProtected(t *testing.T) {
+	cloud := newFakeCloud()
+	protected := cloud.addInstance("db", InstanceStateStopped)
+	scratch := cloud.addInstance("scratch", InstanceStateStopped)
+	cloud.instances[protected]["context"] = map[string]interface{}{"protected": "yes"}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	hc.AddPolicy(DenyDeleteProtected("protected"), MaxInstanceMemory(4096))
+
+	_, err := hc.InstanceDelete(protected)
+	if len(err) != 1 {
+		t.Fatalf("Expected the delete to be denied, got %v", err)
+	}
+	if perr, ok := err[0].(*PolicyError); !ok || perr.Rule != "deny-delete-protected" {
+		t.Errorf("Expected a deny-delete-protected policy error, got %v", err[0])
+	}
+	if _, ok := cloud.instances[protected]; !ok {
+		t.Errorf("Protected instance was deleted")
+	}
+	for _, call := range cloud.calls {
+		if call == "DELETE /instances/"+protected {
+			t.Errorf("Denied request was still sent")
+		}
+	}
+
+	if _, err := hc.InstanceDelete(scratch); err != nil {
+		t.Errorf("Expected deleting an unprotected instance to be allowed: %v", err)
+	}
+
+	_, err = hc.InstanceAssemble(map[string]interface{}{"name": "big", "memory": 8192})
+	if perr, ok := err[0].(*PolicyError); len(err) != 1 || !ok || perr.Rule != "max-instance-memory" {
+		t.Errorf("Expected the oversized instance to be denied, got %v", err)
+	}
+}
+
+func TestPolicyDenyDeleteProtectedDisk(t *testing.T) {
+	cloud := newFakeCloud()
+	data := cloud.addDisk("db-data")
+	loose := cloud.addDisk("scratch")
+	db := cloud.addInstance("db", InstanceStateStopped, data)
+	cloud.instances[db]["context"] = map[string]interface{}{"protected": "yes"}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	hc.AddPolicy(DenyDeleteProtected("protected"))
+
+	// The disk has no context, it's protected by the instance it's attached to
+	_, err := hc.DiskDelete(data)
+	if perr, ok := err[0].(*PolicyError); len(err) != 1 || !ok || perr.Rule != "deny-delete-protected" {
+		t.Fatalf("Expected deleting a protected instance's disk to be denied, got %v", err)
+	}
+	if _, ok := cloud.disks[data]; !ok {
+		t.Errorf("Protected disk was deleted")
+	}
+	if _, err := hc.DiskDelete(loose); err != nil {
+		t.Errorf("Expected deleting an unattached disk to be allowed: %v", err)
+	}
+}
+
+func TestPolicyFailsClosed(t *testing.T) {
+	cloud := newFakeCloud()
+	protected := cloud.addInstance("db", InstanceStateStopped)
+	cloud.instances[protected]["context"] = map[string]interface{}{"protected": "yes"}
+	cloud.failures["GET /instances/"+protected] = 503
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	hc.AddPolicy(DenyDeleteProtected("protected"))
+
+	_, err := hc.InstanceDelete(protected)
+	if perr, ok := err[0].(*PolicyError); len(err) != 1 || !ok || perr.Rule != "target-lookup" {
+		t.Fatalf("Expected the delete to be denied when the target can't be fetched, got %v", err)
+	}
+	if _, ok := cloud.instances[protected]; !ok {
+		t.Errorf("Instance was deleted without its protection being checked")
+	}
+}
+
+func TestPolicyDenyUnprotect(t *testing.T) {
+	cloud := newFakeCloud()
+	db := cloud.addInstance("db", InstanceStateStopped)
+	cloud.instances[db]["context"] = map[string]interface{}{"protected": "yes", "team": "data"}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	hc.AddPolicy(DenyDeleteProtected("protected"))
+
+	denied := func(what string, err []error) {
+		if perr, ok := err[0].(*PolicyError); len(err) != 1 || !ok || perr.Rule != "deny-unprotect" {
+			t.Errorf("%s: expected deny-unprotect, got %v", what, err)
+		}
+	}
+	// Unprotect then delete, every way of unprotecting is blocked
+	_, err := hc.InstanceDeleteContextKey(db, "protected")
+	denied("deleting the key", err)
+	_, err = hc.InstanceUpdateContext(db, map[string]interface{}{"protected": "false", "team": "data"})
+	denied("setting it to false", err)
+	_, err = hc.InstanceSetContext(db, map[string]interface{}{"team": "web"})
+	denied("writing a context without it", err)
+	if _, err := hc.InstanceDelete(db); err == nil {
+		t.Errorf("Expected the delete to be denied")
+	}
+	if _, ok := cloud.instances[db]; !ok {
+		t.Fatalf("Protected instance was deleted")
+	}
+
+	// Other keys can still be changed
+	if _, err := hc.InstanceDeleteContextKey(db, "team"); err != nil {
+		if _, ok := err[0].(*PolicyError); ok {
+			t.Errorf("Expected removing another key to be allowed, got %v", err)
+		}
+	}
+	if _, err := hc.InstanceUpdateContext(db, map[string]interface{}{"protected": "yes", "team": "web"}); err != nil {
+		if _, ok := err[0].(*PolicyError); ok {
+			t.Errorf("Expected a write keeping the key to be allowed, got %v", err)
+		}
+	}
+
+	// Only with an explicit override, and only on the copy
+	for _, call := range cloud.calls {
+		if call == "DELETE /instances/"+db+"/context/protected" {
+			t.Fatalf("Denied request was still sent")
+		}
+	}
+	hc.Override("deny-unprotect").InstanceDeleteContextKey(db, "protected")
+	found := false
+	for _, call := range cloud.calls {
+		found = found || call == "DELETE /instances/"+db+"/context/protected"
+	}
+	if !found {
+		t.Errorf("Expected the overridden call to be sent")
+	}
+	_, err = hc.InstanceDeleteContextKey(db, "protected")
+	denied("after an override on a copy", err)
+}
+
+func TestPolicyRestrictRegions(t *testing.T) {
+	cloud := newFakeCloud()
+	disk := cloud.addDisk("data")
+	cloud.disks[disk]["region"] = "region-2"
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	hc.AddPolicy(RestrictRegions("region-1"))
+
+	_, err := hc.DiskCreate(map[string]interface{}{"name": "new", "region": "region-2"})
+	if perr, ok := err[0].(*PolicyError); len(err) != 1 || !ok || perr.Rule != "restrict-regions" {
+		t.Errorf("Expected a create in another region to be denied, got %v", err)
+	}
+	// A clone lands in the source's region unless told otherwise
+	_, err = hc.DiskClone(disk, map[string]interface{}{"name": "copy"})
+	if perr, ok := err[0].(*PolicyError); len(err) != 1 || !ok || perr.Rule != "restrict-regions" {
+		t.Errorf("Expected a clone of a disk in another region to be denied, got %v", err)
+	}
+	if _, err := hc.DiskClone(disk, map[string]interface{}{"name": "copy", "region": "region-1"}); err != nil {
+		t.Errorf("Expected a clone into an allowed region to go through, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package hypercloud
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	InstanceStateRunning = "running"
+	InstanceStateStopped = "stopped"
+)
+
+// A single state change observed while driving an instance's power state
+type PowerTransition struct {
+	Action string //start, shutdown, force_stop
+	From   string
+	To     string
+	At     time.Time
+}
+
+// What actually happened during a power operation
+type PowerResult struct {
+	InstanceId  string
+	Initial     string
+	Final       string
+	Forced      bool //true if a graceful shutdown had to be escalated to a forced stop
+	Transitions []PowerTransition
+}
+
+func (r *PowerResult) record(action string, from string, to string) {
+	if to == "" {
+		return
+	}
+	r.Transitions = append(r.Transitions, PowerTransition{action, from, to, time.Now()})
+	r.Final = to
+}
+
+/* InstanceState has been seen to return both a bare string and an object, handle both */
+func (h *hypercloud) InstanceCurrentState(instanceId string) (state string, err []error) {
+	ret, err := h.InstanceState(instanceId)
+	if err != nil {
+		return
+	}
+	if s, ok := ret.(string); ok {
+		state = s
+		return
+	}
+	state = stringOf(ret, "state")
+	if state == "" {
+		err = append(err, fmt.Errorf("Unable to determine state of instance %s", instanceId))
+	}
+	return
+}
+
+// Polls InstanceState until the instance reaches the wanted state or the timeout passes.
+// Failed polls are retried until the timeout, the last failure is returned if
+// the state couldn't be read at the end.
+func (h *hypercloud) InstanceWaitState(instanceId string, want string, timeout time.Duration) (state string, err []error) {
+	state, _, err = h.instanceWaitState(instanceId, want, timeout)
+	return
+}
+
+// timedOut is only set when the state was read and simply never became want,
+// callers escalating on a timeout must not take a failing API for a stuck instance
+func (h *hypercloud) instanceWaitState(instanceId string, want string, timeout time.Duration) (state string, timedOut bool, err []error) {
+	h, done := h.workflow("InstanceWaitState", map[string]string{"instance_id": instanceId, "want": want})
+	defer func() { done(err) }()
+	end := time.Now().Add(timeout)
+	for {
+		current, erro := h.InstanceCurrentState(instanceId)
+		if erro == nil {
+			state = current
+			if state == want {
+				return
+			}
+		}
+		if !end.After(time.Now()) {
+			if erro != nil {
+				err = erro
+				return
+			}
+			timedOut = true
+			err = append(err, fmt.Errorf("Timed out waiting for instance %s to become %s (currently %s)", instanceId, want, state))
+			return
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func (h *hypercloud) InstancePowerOn(instanceId string, timeout time.Duration) (res PowerResult, err []error) {
+	res.InstanceId = instanceId
+	res.Initial, err = h.InstanceCurrentState(instanceId)
+	res.Final = res.Initial
+	if err != nil || res.Initial == InstanceStateRunning {
+		return
+	}
+	err = h.powerOn(&res, timeout)
+	return
+}
+
+// Asks the guest to shut down via ACPI, if it hasn't stopped within the timeout the
+// instance is forcibly stopped. res.Forced says which one it took.
+func (h *hypercloud) InstanceShutdown(instanceId string, timeout time.Duration) (res PowerResult, err []error) {
+	res.InstanceId = instanceId
+	res.Initial, err = h.InstanceCurrentState(instanceId)
+	res.Final = res.Initial
+	if err != nil || res.Initial == InstanceStateStopped {
+		return
+	}
+	err = h.powerOff(&res, timeout)
+	return
+}
+
+func (h *hypercloud) InstanceForceStop(instanceId string, timeout time.Duration) (res PowerResult, err []error) {
+	res.InstanceId = instanceId
+	res.Initial, err = h.InstanceCurrentState(instanceId)
+	res.Final = res.Initial
+	if err != nil || res.Initial == InstanceStateStopped {
+		return
+	}
+	err = h.forceStop(&res, timeout)
+	return
+}
+
+// Shutdown (escalating if needed) followed by a start. A stopped instance is simply started.
+func (h *hypercloud) InstanceReboot(instanceId string, timeout time.Duration) (res PowerResult, err []error) {
+	res.InstanceId = instanceId
+	res.Initial, err = h.InstanceCurrentState(instanceId)
+	res.Final = res.Initial
+	if err != nil {
+		return
+	}
+	if res.Initial != InstanceStateStopped {
+		if err = h.powerOff(&res, timeout); err != nil {
+			return
+		}
+	}
+	err = h.powerOn(&res, timeout)
+	return
+}
+
+func (h *hypercloud) powerOn(res *PowerResult, timeout time.Duration) (err []error) {
+	if _, err = h.InstanceStart(res.InstanceId, nil); err != nil {
+		return
+	}
+	from := res.Final
+	state, err := h.InstanceWaitState(res.InstanceId, InstanceStateRunning, timeout)
+	res.record("start", from, state)
+	return
+}
+
+func (h *hypercloud) powerOff(res *PowerResult, timeout time.Duration) (err []error) {
+	if _, err = h.InstanceStop(res.InstanceId, map[string]interface{}{"force": false}); err != nil {
+		return
+	}
+	from := res.Final
+	state, timedOut, err := h.instanceWaitState(res.InstanceId, InstanceStateStopped, timeout)
+	if err == nil {
+		res.record("shutdown", from, state)
+		return
+	}
+	if !timedOut {
+		// Can't tell what the guest is doing, don't pull the plug on it
+		res.record("shutdown", from, state)
+		return
+	}
+	// Guest ignored the ACPI request (or is still going), pull the plug
+	if state != from {
+		res.record("shutdown", from, state)
+	}
+	res.Forced = true
+	return h.forceStop(res, timeout)
+}
+
+func (h *hypercloud) forceStop(res *PowerResult, timeout time.Duration) (err []error) {
+	if _, err = h.InstanceStop(res.InstanceId, map[string]interface{}{"force": true}); err != nil {
+		return
+	}
+	from := res.Final
+	state, err := h.InstanceWaitState(res.InstanceId, InstanceStateStopped, timeout)
+	res.record("force_stop", from, state)
+	return
+}
@@ -0,0 +1,130 @@
+package hypercloud
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Spins up a local API server and a client pointed at it
+func newTestHypercloud(t *testing.T, handler http.HandlerFunc) hypercloud {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	hc, _ := NewHypercloud(srv.URL, "test-token")
+	return hc
+}
+
+func writeJson(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// A pretend instance which only reacts to a forced stop, unless acpi is set
+type stubbornInstance struct {
+	sync.Mutex
+	state string
+	stops []interface{}
+
+	acpi          bool
+	stateFailures int //state requests to fail with a 503 once a stop has been asked for
+}
+
+func (s *stubbornInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/state"):
+		if len(s.stops) > 0 && s.stateFailures > 0 {
+			s.stateFailures--
+			writeJson(w, 503, map[string]interface{}{"error": "unavailable"})
+			return
+		}
+		writeJson(w, 200, map[string]interface{}{"state": s.state})
+	case strings.HasSuffix(r.URL.Path, "/stop"):
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.stops = append(s.stops, body["force"])
+		if body["force"] == true || s.acpi {
+			s.state = InstanceStateStopped
+		}
+		writeJson(w, 200, map[string]interface{}{})
+	case strings.HasSuffix(r.URL.Path, "/start"):
+		s.state = InstanceStateRunning
+		writeJson(w, 200, map[string]interface{}{})
+	default:
+		writeJson(w, 404, map[string]interface{}{"error": "not found"})
+	}
+}
+
+func TestInstanceShutdownEscalates(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	inst := &stubbornInstance{state: InstanceStateRunning}
+	hc := newTestHypercloud(t, inst.ServeHTTP)
+
+	res, err := hc.InstanceShutdown("i-1", 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if !res.Forced {
+		t.Errorf("Expected shutdown to be escalated to a forced stop")
+	}
+	if res.Final != InstanceStateStopped {
+		t.Errorf("Expected final state stopped, got %s", res.Final)
+	}
+	if len(inst.stops) != 2 || inst.stops[0] != false || inst.stops[1] != true {
+		t.Errorf("Expected a graceful then a forced stop, got %v", inst.stops)
+	}
+	if len(res.Transitions) != 1 || res.Transitions[0].Action != "force_stop" {
+		t.Errorf("Unexpected transitions: %+v", res.Transitions)
+	}
+}
+
+func TestInstanceShutdownRidesOutStateErrors(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	inst := &stubbornInstance{state: InstanceStateRunning, acpi: true, stateFailures: 1}
+	hc := newTestHypercloud(t, inst.ServeHTTP)
+
+	res, err := hc.InstanceShutdown("i-1", time.Second)
+	if err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if res.Forced || len(inst.stops) != 1 {
+		t.Errorf("Expected a failed state poll not to escalate, stops were %v", inst.stops)
+	}
+	if res.Final != InstanceStateStopped {
+		t.Errorf("Expected final state stopped, got %s", res.Final)
+	}
+}
+
+func TestInstanceShutdownDoesNotForceOnErrors(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	inst := &stubbornInstance{state: InstanceStateRunning, stateFailures: 1000}
+	hc := newTestHypercloud(t, inst.ServeHTTP)
+
+	res, err := hc.InstanceShutdown("i-1", 50*time.Millisecond)
+	if err == nil {
+		t.Fatalf("Expected the state errors to be returned")
+	}
+	if res.Forced || len(inst.stops) != 1 {
+		t.Errorf("Expected no forced stop while the state can't be read, stops were %v", inst.stops)
+	}
+}
+
+func TestInstanceReboot(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	inst := &stubbornInstance{state: InstanceStateStopped}
+	hc := newTestHypercloud(t, inst.ServeHTTP)
+
+	res, err := hc.InstanceReboot("i-1", time.Second)
+	if err != nil {
+		t.Fatalf("Reboot failed: %v", err)
+	}
+	if res.Forced || res.Final != InstanceStateRunning || len(res.Transitions) != 1 {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+}
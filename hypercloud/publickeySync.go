@@ -0,0 +1,242 @@
+package hypercloud
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// An OpenSSH public key as found in id_*.pub and authorized_keys files
+type SSHPublicKey struct {
+	Type    string
+	Blob    []byte
+	Comment string
+}
+
+var sshKeyTypes = map[string]bool{
+	"ssh-rsa":                            true,
+	"ssh-dss":                            true,
+	"ssh-ed25519":                        true,
+	"ecdsa-sha2-nistp256":                true,
+	"ecdsa-sha2-nistp384":                true,
+	"ecdsa-sha2-nistp521":                true,
+	"sk-ssh-ed25519@openssh.com":         true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// Parses a single public key line. authorized_keys options in front of the key are skipped.
+func ParseSSHPublicKey(line string) (key SSHPublicKey, err error) {
+	fields := strings.Fields(line)
+	for i := 0; i < len(fields); i++ {
+		if !sshKeyTypes[fields[i]] {
+			continue
+		}
+		if i+1 >= len(fields) {
+			break
+		}
+		key.Type = fields[i]
+		key.Blob, err = base64.StdEncoding.DecodeString(fields[i+1])
+		if err != nil {
+			return key, fmt.Errorf("Invalid %s key data: %v", key.Type, err)
+		}
+		// The blob starts with the key type again, make sure they agree
+		if len(key.Blob) < 4 {
+			return key, fmt.Errorf("Invalid %s key data: too short", key.Type)
+		}
+		n := binary.BigEndian.Uint32(key.Blob)
+		if uint32(len(key.Blob)-4) < n || string(key.Blob[4:4+n]) != key.Type {
+			return key, fmt.Errorf("Invalid %s key data: type mismatch", key.Type)
+		}
+		key.Comment = strings.Join(fields[i+2:], " ")
+		return key, nil
+	}
+	return key, fmt.Errorf("No ssh public key found in %q", line)
+}
+
+// Parses every key in an authorized_keys style stream, skipping blanks and comments.
+// Bad lines are reported but don't stop the rest from being read.
+func ParseSSHPublicKeys(r io.Reader) (keys []SSHPublicKey, err []error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, erro := ParseSSHPublicKey(line)
+		if erro != nil {
+			err = append(err, fmt.Errorf("line %d: %v", lineNo, erro))
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if erro := scanner.Err(); erro != nil {
+		err = append(err, erro)
+	}
+	return
+}
+
+func ReadSSHPublicKeyFile(path string) (keys []SSHPublicKey, err []error) {
+	f, erro := os.Open(path)
+	if erro != nil {
+		err = append(err, erro)
+		return
+	}
+	defer f.Close()
+	keys, err = ParseSSHPublicKeys(f)
+	for i := range err {
+		err[i] = fmt.Errorf("%s: %v", path, err[i])
+	}
+	return
+}
+
+// Same format as `ssh-keygen -l`, i.e. SHA256:base64
+func (k SSHPublicKey) FingerprintSHA256() string {
+	sum := sha256.Sum256(k.Blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Legacy colon separated hex format, i.e. MD5:aa:bb:...
+func (k SSHPublicKey) FingerprintMD5() string {
+	sum := md5.Sum(k.Blob)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return "MD5:" + strings.Join(hex, ":")
+}
+
+// Matches either fingerprint format, with or without the hash prefix
+func (k SSHPublicKey) HasFingerprint(fp string) bool {
+	fp = strings.TrimSpace(fp)
+	sha, md := k.FingerprintSHA256(), k.FingerprintMD5()
+	return fp == sha || fp == md || fp == strings.TrimPrefix(sha, "SHA256:") || strings.ToLower(fp) == strings.TrimPrefix(md, "MD5:")
+}
+
+// The authorized_keys form of the key
+func (k SSHPublicKey) String() string {
+	s := k.Type + " " + base64.StdEncoding.EncodeToString(k.Blob)
+	if k.Comment != "" {
+		s += " " + k.Comment
+	}
+	return s
+}
+
+// What PublicKeySync did to the account
+type PublicKeySyncResult struct {
+	Uploaded []string //ids of newly created keys
+	Existing []string //ids of keys that were already there
+	Removed  []string //ids of stale keys that were deleted
+}
+
+// Looks for an uploaded key with the same fingerprint, returns its id
+func (h *hypercloud) PublicKeyFind(key SSHPublicKey) (pkId string, err []error) {
+	list, err := h.PublicKeyList()
+	if err != nil {
+		return
+	}
+	for _, pk := range sliceOf(list) {
+		if uploadedKeyMatches(pk, key) {
+			pkId = idOf(pk)
+			return
+		}
+	}
+	return
+}
+
+// Uploads a key unless one with the same fingerprint is already on the account.
+// The name defaults to the key's comment, or its fingerprint if it has none.
+func (h *hypercloud) PublicKeyUpload(key SSHPublicKey, name string) (pkId string, created bool, err []error) {
+	pkId, err = h.PublicKeyFind(key)
+	if err != nil || pkId != "" {
+		return
+	}
+	ret, err := h.PublicKeyCreate(publicKeyBody(key, name))
+	if err != nil {
+		return
+	}
+	return idOf(ret), true, nil
+}
+
+// Makes sure every key in keys is on the account. With removeStale any other
+// uploaded key is deleted, leaving the account with exactly the desired set.
+func (h *hypercloud) PublicKeySync(keys []SSHPublicKey, removeStale bool) (res PublicKeySyncResult, err []error) {
+	list, err := h.PublicKeyList()
+	if err != nil {
+		return
+	}
+	uploaded := sliceOf(list)
+	wanted := make(map[string]bool)
+	for _, key := range keys {
+		pkId := ""
+		for _, pk := range uploaded {
+			if uploadedKeyMatches(pk, key) {
+				pkId = idOf(pk)
+				break
+			}
+		}
+		if pkId != "" {
+			if !wanted[pkId] {
+				res.Existing = append(res.Existing, pkId)
+			}
+			wanted[pkId] = true
+			continue
+		}
+		ret, erro := h.PublicKeyCreate(publicKeyBody(key, ""))
+		if erro != nil {
+			err = append(err, erro...)
+			continue
+		}
+		pkId = idOf(ret)
+		wanted[pkId] = true
+		res.Uploaded = append(res.Uploaded, pkId)
+		uploaded = append(uploaded, ret)
+	}
+	// Never remove anything if we failed to upload part of the desired set
+	if !removeStale || err != nil {
+		return
+	}
+	for _, pk := range uploaded {
+		pkId := idOf(pk)
+		if pkId == "" || wanted[pkId] {
+			continue
+		}
+		if _, erro := h.PublicKeyDelete(pkId); erro != nil {
+			err = append(err, erro...)
+			continue
+		}
+		res.Removed = append(res.Removed, pkId)
+	}
+	return
+}
+
+func publicKeyBody(key SSHPublicKey, name string) map[string]interface{} {
+	if name == "" {
+		name = key.Comment
+	}
+	if name == "" {
+		name = key.FingerprintSHA256()
+	}
+	return map[string]interface{}{"name": name, "key": key.String()}
+}
+
+// Compares against the uploaded key material, falling back on the fingerprint the API reports
+func uploadedKeyMatches(pk interface{}, key SSHPublicKey) bool {
+	if material := stringOf(pk, "key"); material != "" {
+		if other, erro := ParseSSHPublicKey(material); erro == nil {
+			return other.Type == key.Type && string(other.Blob) == string(key.Blob)
+		}
+	}
+	if fp := stringOf(pk, "fingerprint"); fp != "" {
+		return key.HasFingerprint(fp)
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package hypercloud
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Generated with ssh-keygen -t ed25519, fingerprints from ssh-keygen -l and ssh-keygen -l -E md5
+const (
+	aliceKey    = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ3L283MYEhTKOCLaiVpbuaXHf2FALF+zWXE8Q2H7RG3 alice@laptop"
+	aliceSHA256 = "SHA256:itTjF61epwyF4wl5BM5ABw6i7rP56qX006Xvxn+737Y"
+	aliceMD5    = "MD5:89:eb:c6:cf:cd:f6:e9:ba:99:c3:4b:b9:75:3d:1d:f5"
+	bobKey      = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDopnL5/TgiJD6NBVPolDke0SGNjyU9ailB36y8xzIYh bob"
+	bobSHA256   = "SHA256:wcaCCjfDwcTOQ0MbI31iOnyPq7PnyKL4TqJFASy48yM"
+)
+
+func TestSSHPublicKeyFingerprints(t *testing.T) {
+	key, err := ParseSSHPublicKey(aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.FingerprintSHA256() != aliceSHA256 {
+		t.Errorf("Expected %s, got %s", aliceSHA256, key.FingerprintSHA256())
+	}
+	if key.FingerprintMD5() != aliceMD5 {
+		t.Errorf("Expected %s, got %s", aliceMD5, key.FingerprintMD5())
+	}
+	for _, fp := range []string{aliceSHA256, aliceMD5, strings.TrimPrefix(aliceSHA256, "SHA256:"), strings.ToUpper(strings.TrimPrefix(aliceMD5, "MD5:"))} {
+		if !key.HasFingerprint(fp) {
+			t.Errorf("Expected %s to match", fp)
+		}
+	}
+	if key.HasFingerprint(bobSHA256) {
+		t.Errorf("Expected another key's fingerprint not to match")
+	}
+	if key.String() != aliceKey {
+		t.Errorf("Expected the key to round trip, got %s", key.String())
+	}
+}
+
+func TestParseSSHPublicKeys(t *testing.T) {
+	blob := strings.Fields(aliceKey)[1]
+	keys, err := ParseSSHPublicKeys(strings.NewReader(`# deploy keys
+
+command="/usr/bin/backup",no-pty,from="10.0.0.0/8" ` + aliceKey + `
+no-agent-forwarding ` + bobKey + `
+ssh-rsa ` + blob + ` ed25519 data claiming to be rsa
+ssh-ed25519 !!!notbase64
+`))
+	if len(keys) != 2 || keys[0].FingerprintSHA256() != aliceSHA256 || keys[0].Comment != "alice@laptop" || keys[1].FingerprintSHA256() != bobSHA256 {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+	if len(err) != 2 || !strings.Contains(err[0].Error(), "line 5: Invalid ssh-rsa key data: type mismatch") || !strings.Contains(err[1].Error(), "line 6") {
+		t.Errorf("Expected the mismatched and corrupt lines to be reported, got %v", err)
+	}
+}
+
+func TestPublicKeySync(t *testing.T) {
+	cloud := newFakeCloud()
+	alice, _ := ParseSSHPublicKey(aliceKey)
+	bob, _ := ParseSSHPublicKey(bobKey)
+	// Already uploaded, known only by its fingerprint
+	cloud.keys["key-alice"] = map[string]interface{}{"id": "key-alice", "name": "alice", "fingerprint": aliceMD5}
+	cloud.keys["key-stale"] = map[string]interface{}{"id": "key-stale", "name": "old", "key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBIgpxqrRmeSvMtyd2yCFXnKjk7I/BWsZ8X3RbRTfzIk old"}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	res, err := hc.PublicKeySync([]SSHPublicKey{alice, bob, alice}, false)
+	if err != nil {
+		t.Fatalf("PublicKeySync failed: %v", err)
+	}
+	if !reflect.DeepEqual(res.Existing, []string{"key-alice"}) || len(res.Uploaded) != 1 || res.Removed != nil {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if stringOf(cloud.keys[res.Uploaded[0]], "name") != "bob" {
+		t.Errorf("Expected the key to be named after its comment, got %v", cloud.keys[res.Uploaded[0]])
+	}
+	if _, ok := cloud.keys["key-stale"]; !ok {
+		t.Errorf("Stale key removed without removeStale")
+	}
+
+	res, err = hc.PublicKeySync([]SSHPublicKey{alice, bob}, true)
+	if err != nil {
+		t.Fatalf("PublicKeySync failed: %v", err)
+	}
+	sort.Strings(res.Existing)
+	if len(res.Uploaded) != 0 || len(res.Existing) != 2 || !reflect.DeepEqual(res.Removed, []string{"key-stale"}) {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if len(cloud.keys) != 2 {
+		t.Errorf("Expected only the desired keys to be left, got %v", cloud.keys)
+	}
+}
+
+func TestPublicKeySyncKeepsStaleOnFailure(t *testing.T) {
+	cloud := newFakeCloud()
+	cloud.keys["key-stale"] = map[string]interface{}{"id": "key-stale", "fingerprint": bobSHA256}
+	cloud.failures["POST /public_keys"] = 500
+	alice, _ := ParseSSHPublicKey(aliceKey)
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	if _, err := hc.PublicKeySync([]SSHPublicKey{alice}, true); err == nil {
+		t.Errorf("Expected the failed upload to be reported")
+	}
+	if _, ok := cloud.keys["key-stale"]; !ok {
+		t.Errorf("Expected nothing to be removed when an upload failed")
+	}
+}
@@ -0,0 +1,217 @@
+package hypercloud
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Checking a plan against the account's limits before creating anything, rather
+// than finding out from a 422 halfway through.
+
+// Resource totals, used both for usage and for limits. A zero limit is not enforced.
+type ResourceTotals struct {
+	Instances int     `json:"instances"`
+	MemoryMB  float64 `json:"memory"`
+	DiskGB    float64 `json:"disk"`
+	IPs       int     `json:"ip_addresses"`
+}
+
+func (t *ResourceTotals) add(spec ResourceSpec) {
+	switch spec.Kind {
+	case "instance":
+		t.Instances++
+		t.MemoryMB += spec.MemoryMB
+	case "disk":
+		t.DiskGB += spec.SizeGB
+	case "ip":
+		t.IPs++
+	}
+}
+
+// Limits by region id or code. The "*" entry applies to regions without their own.
+type QuotaLimits map[string]ResourceTotals
+
+// The region's own entry by id, else by code (the first matching key in sorted
+// order, should several differ only in case), else "*"
+func (q QuotaLimits) forRegion(region string, code string) (ResourceTotals, bool) {
+	if v, ok := q[region]; ok {
+		return v, true
+	}
+	if code != "" {
+		keys := make([]string, 0, len(q))
+		for k := range q {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if strings.EqualFold(k, code) {
+				return q[k], true
+			}
+		}
+	}
+	v, ok := q["*"]
+	return v, ok
+}
+
+// Returned by AccountLimits when the API doesn't expose limits: no path is set,
+// or the one that is gives a 404
+var ErrNoAccountLimits = errors.New("The API doesn't expose account limits")
+
+// The HyperCloud API reference doesn't document an endpoint for account limits,
+// so by default none is queried and PreflightCheck goes by configured limits.
+// Deployments which do expose them can point the client at theirs, e.g.
+// "/account/limits". It should return either one object or a list of them with
+// a "region", each with "instances", "memory" (MB), "disk" (GB) and
+// "ip_addresses" figures.
+func (h *hypercloud) SetAccountLimitsPath(path string) {
+	h.limitsPath = path
+}
+
+// Account limits as reported by the API, from the path given to
+// SetAccountLimitsPath. Without one, or if the API doesn't know it, the error
+// is ErrNoAccountLimits and configured limits have to be used.
+func (h *hypercloud) AccountLimits() (limits QuotaLimits, err []error) {
+	if h.limitsPath == "" {
+		return nil, []error{ErrNoAccountLimits}
+	}
+	ret, status, err := h.request("GET", h.limitsPath, nil)
+	if status == 404 {
+		return nil, []error{ErrNoAccountLimits}
+	}
+	if err != nil {
+		return
+	}
+	limits = make(QuotaLimits)
+	parse := func(data interface{}) ResourceTotals {
+		return ResourceTotals{
+			Instances: int(numberOf(data, "instances")),
+			MemoryMB:  numberOf(data, "memory"),
+			DiskGB:    numberOf(data, "disk"),
+			IPs:       int(numberOf(data, "ip_addresses")),
+		}
+	}
+	if list := sliceOf(ret); list != nil {
+		for _, l := range list {
+			region := idOf(mapOf(l)["region"])
+			if region == "" {
+				region = "*"
+			}
+			limits[region] = parse(l)
+		}
+	} else {
+		limits["*"] = parse(ret)
+	}
+	return
+}
+
+type RegionUsage struct {
+	Region  string
+	Current ResourceTotals
+	Planned ResourceTotals
+	Limit   ResourceTotals
+	Limited bool //false if no limit applies to the region
+}
+
+type PreflightReport struct {
+	Regions    []RegionUsage
+	Violations []string
+}
+
+func (r PreflightReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// Adds the planned resources to what the account already has and compares the
+// totals per region against the limits: the API's if it exposes them,
+// otherwise configured. Every limit the plan would break is returned as an error,
+// as is any failure to fetch the API's limits other than them not being exposed.
+func (h *hypercloud) PreflightCheck(plan []ResourceSpec, configured QuotaLimits) (report PreflightReport, err []error) {
+	limits, err := h.AccountLimits()
+	if len(err) == 1 && err[0] == ErrNoAccountLimits {
+		if configured == nil {
+			err = []error{fmt.Errorf("No account limits available from the API and none configured")}
+			return
+		}
+		limits, err = configured, nil
+	}
+	if err != nil {
+		return
+	}
+	current, err := h.AccountSnapshot()
+	if err != nil {
+		return
+	}
+	codes, err := h.regionCodes()
+	if err != nil {
+		return
+	}
+
+	usage := make(map[string]*RegionUsage)
+	get := func(region string) *RegionUsage {
+		// Plans may use codes (SY3), the API uses ids
+		for id, code := range codes {
+			if strings.EqualFold(code, region) {
+				region = id
+			}
+		}
+		if u, ok := usage[region]; ok {
+			return u
+		}
+		u := &RegionUsage{Region: region}
+		u.Limit, u.Limited = limits.forRegion(region, codes[region])
+		usage[region] = u
+		return u
+	}
+	for _, spec := range current {
+		u := get(spec.Region)
+		u.Current.add(spec)
+	}
+	for _, spec := range plan {
+		u := get(spec.Region)
+		u.Planned.add(spec)
+	}
+
+	regions := make([]string, 0, len(usage))
+	for r := range usage {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+	for _, r := range regions {
+		u := usage[r]
+		report.Regions = append(report.Regions, *u)
+		if !u.Limited {
+			continue
+		}
+		name := r
+		if codes[r] != "" {
+			name = codes[r]
+		}
+		check := func(what string, current float64, planned float64, limit float64) {
+			if limit > 0 && planned > 0 && current+planned > limit {
+				report.Violations = append(report.Violations, fmt.Sprintf("%s: %s would be %g (%g in use + %g planned), limit is %g", name, what, current+planned, current, planned, limit))
+			}
+		}
+		check("instances", float64(u.Current.Instances), float64(u.Planned.Instances), float64(u.Limit.Instances))
+		check("memory (MB)", u.Current.MemoryMB, u.Planned.MemoryMB, u.Limit.MemoryMB)
+		check("disk (GB)", u.Current.DiskGB, u.Planned.DiskGB, u.Limit.DiskGB)
+		check("IP addresses", float64(u.Current.IPs), float64(u.Planned.IPs), float64(u.Limit.IPs))
+	}
+	for _, v := range report.Violations {
+		err = append(err, fmt.Errorf("Quota exceeded: %s", v))
+	}
+	return
+}
+
+func (h *hypercloud) regionCodes() (codes map[string]string, err []error) {
+	regions, err := h.RegionList()
+	if err != nil {
+		return
+	}
+	codes = make(map[string]string)
+	for _, r := range sliceOf(regions) {
+		codes[idOf(r)] = stringOf(r, "code")
+	}
+	return
+}
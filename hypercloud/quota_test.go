@@ -0,0 +1,85 @@
+package hypercloud
+
+import (
+	"strings"
+	"testing"
+)
+
+func quotaCloud() *fakeCloud {
+	cloud := newFakeCloud()
+	cloud.lists["/regions"] = []interface{}{map[string]interface{}{"id": "region-1", "code": "SY3"}}
+	web := cloud.addInstance("web", InstanceStateRunning)
+	cloud.instances[web]["region"] = "region-1"
+	cloud.instances[web]["memory"] = 2048.0
+	return cloud
+}
+
+func TestPreflightCheckAPILimits(t *testing.T) {
+	cloud := quotaCloud()
+	cloud.lists["/account/limits"] = []interface{}{
+		map[string]interface{}{"region": "region-1", "instances": 5, "memory": 4096},
+	}
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	hc.SetAccountLimitsPath("/account/limits")
+
+	plan := []ResourceSpec{{Kind: "instance", Name: "db", Region: "SY3", MemoryMB: 4096}}
+	// The API's limits win over configured ones
+	report, err := hc.PreflightCheck(plan, QuotaLimits{"*": {MemoryMB: 100000}})
+	if len(err) != 1 || !strings.Contains(err[0].Error(), "memory (MB) would be 6144") {
+		t.Fatalf("Expected the plan to break the region's memory limit, got %v", err)
+	}
+	if report.OK() || len(report.Regions) != 1 || report.Regions[0].Current.Instances != 1 || report.Regions[0].Planned.Instances != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	plan[0].MemoryMB = 1024
+	if _, err := hc.PreflightCheck(plan, nil); err != nil {
+		t.Errorf("Expected a plan within the limits to pass, got %v", err)
+	}
+}
+
+func TestPreflightCheckConfiguredLimits(t *testing.T) {
+	cloud := quotaCloud() //no /account/limits, so a 404
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+	plan := []ResourceSpec{{Kind: "instance", Name: "db", Region: "SY3", MemoryMB: 1024}}
+
+	if _, err := hc.AccountLimits(); len(err) != 1 || err[0] != ErrNoAccountLimits {
+		t.Errorf("Expected ErrNoAccountLimits without a path, got %v", err)
+	}
+	hc.SetAccountLimitsPath("/account/limits")
+	if _, err := hc.AccountLimits(); len(err) != 1 || err[0] != ErrNoAccountLimits {
+		t.Errorf("Expected ErrNoAccountLimits, got %v", err)
+	}
+	if _, err := hc.PreflightCheck(plan, QuotaLimits{"SY3": {Instances: 1}}); len(err) != 1 || !strings.Contains(err[0].Error(), "instances would be 2") {
+		t.Errorf("Expected the configured instance limit to apply, got %v", err)
+	}
+	if _, err := hc.PreflightCheck(plan, nil); len(err) != 1 || !strings.Contains(err[0].Error(), "none configured") {
+		t.Errorf("Expected an error without any limits, got %v", err)
+	}
+
+	// Anything other than a 404 is a real failure, not a reason to fall back
+	cloud.failures["GET /account/limits"] = 500
+	if _, err := hc.PreflightCheck(plan, QuotaLimits{"*": {Instances: 10}}); len(err) != 1 || !strings.Contains(err[0].Error(), "500") {
+		t.Errorf("Expected the API error to be returned, got %v", err)
+	}
+}
+
+func TestQuotaLimitsForRegion(t *testing.T) {
+	limits := QuotaLimits{"sy3": {Instances: 1}, "SY3": {Instances: 2}, "region-1": {Instances: 3}, "*": {Instances: 4}}
+	tests := []struct {
+		region, code string
+		want         int
+	}{
+		{"region-1", "SY3", 3},
+		{"region-2", "Sy3", 2}, //"SY3" sorts before "sy3"
+		{"region-3", "ME1", 4},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ { //map order must not matter
+			if got, ok := limits.forRegion(tt.region, tt.code); !ok || got.Instances != tt.want {
+				t.Errorf("%s/%s: expected %d, got %d", tt.region, tt.code, tt.want, got.Instances)
+				break
+			}
+		}
+	}
+}
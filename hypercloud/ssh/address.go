@@ -0,0 +1,140 @@
+/*
+Package ssh gets you a shell on a hypercloud instance.
+
+It works out where an instance can be reached from the addresses attached to its
+network adapters (directly on a public IP, or through a bastion instance when it
+only has private ones), waits for sshd to come up and then either hands back an
+x/crypto/ssh client or writes an ssh_config stanza for the openssh client. The
+local keys used are matched against the ones attached with InstanceUpdatePublicKeys.
+*/
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// The bits of the hypercloud client this package needs. *hypercloud satisfies it.
+type Client interface {
+	InstanceInfo(instanceId string) (interface{}, []error)
+	IPAddressInfo(IPAddrID string) (interface{}, []error)
+	PublicKeyInfo(pkId string) (interface{}, []error)
+}
+
+// Addresses attached to an instance, split by reachability
+type Addresses struct {
+	Public  []string
+	Private []string
+}
+
+// The address to use from outside the instance's private networks
+func (a Addresses) Preferred() (string, bool) {
+	if len(a.Public) > 0 {
+		return a.Public[0], true
+	}
+	return "", false
+}
+
+// Collects the IPs from the instance's network adapters, looking up any that are
+// only referenced by id.
+func InstanceAddresses(c Client, instanceId string) (addrs Addresses, err []error) {
+	info, err := c.InstanceInfo(instanceId)
+	if err != nil {
+		return
+	}
+	inst, _ := info.(map[string]interface{})
+	adapters, _ := inst["network_adapters"].([]interface{})
+	for _, a := range adapters {
+		adapter, _ := a.(map[string]interface{})
+		ips, _ := adapter["ip_addresses"].([]interface{})
+		for _, ip := range ips {
+			ipInfo := ip
+			if id, ok := ip.(string); ok {
+				var erro []error
+				if ipInfo, erro = c.IPAddressInfo(id); erro != nil {
+					err = append(err, erro...)
+					continue
+				}
+			}
+			m, _ := ipInfo.(map[string]interface{})
+			address, _ := m["address"].(string)
+			parsed := net.ParseIP(address)
+			if parsed == nil {
+				continue
+			}
+			kind, _ := m["type"].(string)
+			if kind == "public" || (kind == "" && !parsed.IsPrivate() && !parsed.IsLoopback()) {
+				addrs.Public = append(addrs.Public, address)
+			} else {
+				addrs.Private = append(addrs.Private, address)
+			}
+		}
+	}
+	return
+}
+
+// Where to connect to for an instance, possibly by way of a bastion
+type Target struct {
+	InstanceId string
+	Host       string
+	Port       int
+	Bastion    *Target
+}
+
+func (t *Target) Addr() string {
+	return net.JoinHostPort(t.Host, fmt.Sprint(t.Port))
+}
+
+// Works out how to reach an instance. With no public address the instance's
+// first private address is used through bastionId, which must have a public one.
+func Resolve(c Client, instanceId string, bastionId string) (t *Target, err []error) {
+	addrs, err := InstanceAddresses(c, instanceId)
+	if err != nil {
+		return
+	}
+	if host, ok := addrs.Preferred(); ok {
+		return &Target{InstanceId: instanceId, Host: host, Port: 22}, nil
+	}
+	if len(addrs.Private) == 0 {
+		err = append(err, fmt.Errorf("Instance %s has no IP addresses attached", instanceId))
+		return
+	}
+	if bastionId == "" {
+		err = append(err, fmt.Errorf("Instance %s only has private addresses and no bastion was given", instanceId))
+		return
+	}
+	bastion, err := Resolve(c, bastionId, "")
+	if err != nil {
+		return
+	}
+	return &Target{InstanceId: instanceId, Host: addrs.Private[0], Port: 22, Bastion: bastion}, nil
+}
+
+// Resolve, with the port from opts
+func resolve(c Client, instanceId string, opts Options) (t *Target, err []error) {
+	if t, err = Resolve(c, instanceId, opts.BastionId); err != nil {
+		return
+	}
+	t.Port = opts.port()
+	if t.Bastion != nil {
+		t.Bastion.Port = opts.port()
+	}
+	return
+}
+
+// Waits for something to be listening on addr, i.e. sshd finishing boot
+func WaitForPort(addr string, timeout time.Duration) error {
+	end := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if !end.After(time.Now()) {
+			return fmt.Errorf("Timed out waiting for %s: %v", addr, err)
+		}
+		time.Sleep(time.Second)
+	}
+}
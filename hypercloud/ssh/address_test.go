@@ -0,0 +1,111 @@
+package ssh
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Serves instances, IPs and keys from maps, like the API would
+type fakeClient struct {
+	instances map[string]map[string]interface{}
+	ips       map[string]map[string]interface{}
+	keys      map[string]map[string]interface{}
+}
+
+func lookup(m map[string]map[string]interface{}, id string) (interface{}, []error) {
+	if v, ok := m[id]; ok {
+		return v, nil
+	}
+	return nil, []error{fmt.Errorf("Invalid request error: %s not found", id)}
+}
+
+func (f *fakeClient) InstanceInfo(instanceId string) (interface{}, []error) {
+	return lookup(f.instances, instanceId)
+}
+
+func (f *fakeClient) IPAddressInfo(id string) (interface{}, []error) {
+	return lookup(f.ips, id)
+}
+
+func (f *fakeClient) PublicKeyInfo(id string) (interface{}, []error) {
+	return lookup(f.keys, id)
+}
+
+func adapter(ips ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"network": "net-1", "ip_addresses": ips}
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		instances: map[string]map[string]interface{}{
+			// Both by id and inline, with and without a type
+			"web": {"id": "web", "network_adapters": []interface{}{
+				adapter("ip-private", map[string]interface{}{"address": "203.0.113.10"}),
+				adapter("ip-public", map[string]interface{}{"address": "10.0.0.11", "type": "private"}, map[string]interface{}{"address": "not an ip"}),
+			}},
+			"db":      {"id": "db", "network_adapters": []interface{}{adapter("ip-db")}},
+			"bastion": {"id": "bastion", "network_adapters": []interface{}{adapter(map[string]interface{}{"address": "198.51.100.1", "type": "public"})}},
+			"bare":    {"id": "bare"},
+			"broken":  {"id": "broken", "network_adapters": []interface{}{adapter("ip-missing")}},
+		},
+		ips: map[string]map[string]interface{}{
+			"ip-private": {"id": "ip-private", "address": "10.0.0.10"},
+			"ip-public":  {"id": "ip-public", "address": "100.64.0.1", "type": "public"},
+			"ip-db":      {"id": "ip-db", "address": "10.0.0.20", "type": "private"},
+		},
+		keys: map[string]map[string]interface{}{},
+	}
+}
+
+func TestInstanceAddresses(t *testing.T) {
+	c := newFakeClient()
+	addrs, err := InstanceAddresses(c, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The API's type wins over guessing from the address
+	want := Addresses{Public: []string{"203.0.113.10", "100.64.0.1"}, Private: []string{"10.0.0.10", "10.0.0.11"}}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Errorf("Expected %+v, got %+v", want, addrs)
+	}
+	if host, ok := addrs.Preferred(); !ok || host != "203.0.113.10" {
+		t.Errorf("Expected the first public address, got %q", host)
+	}
+	if _, err := InstanceAddresses(c, "broken"); len(err) != 1 || !strings.Contains(err[0].Error(), "ip-missing") {
+		t.Errorf("Expected the failed IP lookup to be reported, got %v", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c := newFakeClient()
+	target, err := Resolve(c, "web", "bastion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Addr() != "203.0.113.10:22" || target.Bastion != nil {
+		t.Errorf("Expected a public instance to be reached directly, got %+v", target)
+	}
+
+	target, err = Resolve(c, "db", "bastion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Addr() != "10.0.0.20:22" || target.Bastion == nil || target.Bastion.Addr() != "198.51.100.1:22" || target.Bastion.InstanceId != "bastion" {
+		t.Errorf("Expected the private address through the bastion, got %+v", target)
+	}
+
+	tests := map[string]struct{ instance, bastion, want string }{
+		"no bastion":         {"db", "", "no bastion was given"},
+		"private bastion":    {"db", "db", "no bastion was given"},
+		"no addresses":       {"bare", "bastion", "no IP addresses"},
+		"unknown instance":   {"nope", "bastion", "nope not found"},
+		"unknown bastion id": {"db", "nope", "nope not found"},
+	}
+	for name, tt := range tests {
+		if _, err := Resolve(c, tt.instance, tt.bastion); len(err) != 1 || !strings.Contains(err[0].Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", name, tt.want, err)
+		}
+	}
+}
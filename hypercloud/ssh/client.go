@@ -0,0 +1,215 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+type Options struct {
+	User          string   //defaults to root
+	Port          int      //sshd's port on the instance and bastion, defaults to 22
+	IdentityFiles []string //private keys, only those matching a key attached to the instance are offered
+
+	// Must be set, e.g. from golang.org/x/crypto/ssh/knownhosts
+	HostKeyCallback cryptossh.HostKeyCallback
+
+	BastionId   string //instance to jump through when the target only has private addresses
+	BastionUser string //defaults to User
+
+	WaitTimeout time.Duration //how long to wait for sshd to come up, defaults to 2 minutes
+}
+
+func (o Options) user() string {
+	if o.User == "" {
+		return "root"
+	}
+	return o.User
+}
+
+func (o Options) port() int {
+	if o.Port == 0 {
+		return 22
+	}
+	return o.Port
+}
+
+func (o Options) bastionUser() string {
+	if o.BastionUser == "" {
+		return o.user()
+	}
+	return o.BastionUser
+}
+
+func (o Options) waitTimeout() time.Duration {
+	if o.WaitTimeout == 0 {
+		return 2 * time.Minute
+	}
+	return o.WaitTimeout
+}
+
+// The public keys attached to an instance via InstanceUpdatePublicKeys
+func AttachedKeys(c Client, instanceId string) (keys []hypercloud.SSHPublicKey, err []error) {
+	info, err := c.InstanceInfo(instanceId)
+	if err != nil {
+		return
+	}
+	inst, _ := info.(map[string]interface{})
+	attached, _ := inst["public_keys"].([]interface{})
+	for _, pk := range attached {
+		if id, ok := pk.(string); ok {
+			var erro []error
+			if pk, erro = c.PublicKeyInfo(id); erro != nil {
+				err = append(err, erro...)
+				continue
+			}
+		}
+		m, _ := pk.(map[string]interface{})
+		material, _ := m["key"].(string)
+		key, erro := hypercloud.ParseSSHPublicKey(material)
+		if erro != nil {
+			err = append(err, erro)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return
+}
+
+// An identity file whose public half is attached to the instance
+type Identity struct {
+	Path   string
+	Signer cryptossh.Signer
+}
+
+// Loads the identity files and keeps the ones the instance will accept
+func MatchingIdentities(c Client, instanceId string, files []string) (ids []Identity, err []error) {
+	attached, err := AttachedKeys(c, instanceId)
+	if err != nil {
+		return
+	}
+	for _, path := range files {
+		pem, erro := os.ReadFile(path)
+		if erro != nil {
+			err = append(err, erro)
+			continue
+		}
+		signer, erro := cryptossh.ParsePrivateKey(pem)
+		if erro != nil {
+			err = append(err, fmt.Errorf("%s: %v", path, erro))
+			continue
+		}
+		blob := signer.PublicKey().Marshal()
+		for _, key := range attached {
+			if string(key.Blob) == string(blob) {
+				ids = append(ids, Identity{path, signer})
+				break
+			}
+		}
+	}
+	if err == nil && len(ids) == 0 {
+		err = append(err, fmt.Errorf("None of the identity files match a key attached to instance %s", instanceId))
+	}
+	return
+}
+
+func clientConfig(user string, ids []Identity, hostKeys cryptossh.HostKeyCallback) *cryptossh.ClientConfig {
+	signers := make([]cryptossh.Signer, len(ids))
+	for i := range ids {
+		signers[i] = ids[i].Signer
+	}
+	return &cryptossh.ClientConfig{
+		User:            user,
+		Auth:            []cryptossh.AuthMethod{cryptossh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeys,
+		Timeout:         15 * time.Second,
+	}
+}
+
+// Connects to the instance, going through the bastion if needed, once sshd is up
+func Dial(c Client, instanceId string, opts Options) (*cryptossh.Client, error) {
+	if opts.HostKeyCallback == nil {
+		return nil, fmt.Errorf("Options.HostKeyCallback must be set")
+	}
+	target, errs := resolve(c, instanceId, opts)
+	if errs != nil {
+		return nil, joinErrors(errs)
+	}
+	ids, errs := MatchingIdentities(c, instanceId, opts.IdentityFiles)
+	if errs != nil {
+		return nil, joinErrors(errs)
+	}
+	cfg := clientConfig(opts.user(), ids, opts.HostKeyCallback)
+
+	if target.Bastion == nil {
+		if err := WaitForPort(target.Addr(), opts.waitTimeout()); err != nil {
+			return nil, err
+		}
+		return cryptossh.Dial("tcp", target.Addr(), cfg)
+	}
+
+	bastionIds, errs := MatchingIdentities(c, target.Bastion.InstanceId, opts.IdentityFiles)
+	if errs != nil {
+		return nil, joinErrors(errs)
+	}
+	if err := WaitForPort(target.Bastion.Addr(), opts.waitTimeout()); err != nil {
+		return nil, err
+	}
+	bastion, err := cryptossh.Dial("tcp", target.Bastion.Addr(), clientConfig(opts.bastionUser(), bastionIds, opts.HostKeyCallback))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to bastion %s: %v", target.Bastion.InstanceId, err)
+	}
+	// Can't probe the private address from here, keep trying through the bastion instead
+	var conn net.Conn
+	end := time.Now().Add(opts.waitTimeout())
+	for {
+		conn, err = bastion.Dial("tcp", target.Addr())
+		if err == nil {
+			break
+		}
+		if !end.After(time.Now()) {
+			bastion.Close()
+			return nil, fmt.Errorf("Timed out waiting for %s via bastion: %v", target.Addr(), err)
+		}
+		time.Sleep(time.Second)
+	}
+	sshConn, chans, reqs, err := cryptossh.NewClientConn(conn, target.Addr(), cfg)
+	if err != nil {
+		conn.Close()
+		bastion.Close()
+		return nil, err
+	}
+	client := cryptossh.NewClient(sshConn, chans, reqs)
+	go func() {
+		client.Wait()
+		bastion.Close()
+	}()
+	return client, nil
+}
+
+// Dials the instance and opens a session on it. Closing the client closes the session.
+func NewSession(c Client, instanceId string, opts Options) (*cryptossh.Session, *cryptossh.Client, error) {
+	client, err := Dial(c, instanceId, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return session, client, nil
+}
+
+func joinErrors(errs []error) error {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
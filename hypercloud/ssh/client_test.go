@@ -0,0 +1,194 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// An in-process sshd accepting one user key. Sessions answer every exec with
+// "hello", port forwards are looped back to the server itself, so it can be
+// its own bastion.
+type testServer struct {
+	addr    string
+	hostKey cryptossh.PublicKey
+
+	mu      sync.Mutex
+	logins  []string
+	forward []string //addresses asked for through direct-tcpip
+}
+
+func newTestServer(t *testing.T, userKey cryptossh.PublicKey) *testServer {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := cryptossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &testServer{addr: l.Addr().String(), hostKey: hostSigner.PublicKey()}
+	cfg := &cryptossh.ServerConfig{
+		PublicKeyCallback: func(meta cryptossh.ConnMetadata, key cryptossh.PublicKey) (*cryptossh.Permissions, error) {
+			if string(key.Marshal()) != string(userKey.Marshal()) {
+				return nil, io.EOF
+			}
+			s.mu.Lock()
+			s.logins = append(s.logins, meta.User())
+			s.mu.Unlock()
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) serve(conn net.Conn, cfg *cryptossh.ServerConfig) {
+	_, chans, reqs, err := cryptossh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go cryptossh.DiscardRequests(reqs)
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				for req := range reqs {
+					req.Reply(req.Type == "exec", nil)
+					if req.Type == "exec" {
+						io.WriteString(ch, "hello")
+						ch.SendRequest("exit-status", false, cryptossh.Marshal(struct{ Status uint32 }{0}))
+						ch.Close()
+					}
+				}
+			}()
+		case "direct-tcpip":
+			var dest struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			cryptossh.Unmarshal(nc.ExtraData(), &dest)
+			s.mu.Lock()
+			s.forward = append(s.forward, net.JoinHostPort(dest.Host, strconv.Itoa(int(dest.Port))))
+			s.mu.Unlock()
+			back, err := net.Dial("tcp", s.addr)
+			if err != nil {
+				nc.Reject(cryptossh.ConnectionFailed, err.Error())
+				continue
+			}
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				back.Close()
+				continue
+			}
+			go cryptossh.DiscardRequests(reqs)
+			go func() {
+				io.Copy(back, ch)
+				back.Close()
+			}()
+			go func() {
+				io.Copy(ch, back)
+				ch.Close()
+			}()
+		default:
+			nc.Reject(cryptossh.UnknownChannelType, "not supported")
+		}
+	}
+}
+
+// A user key attached to the instances, written out as an identity file
+func testIdentity(t *testing.T, c *fakeClient) (path string, key cryptossh.PublicKey) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := cryptossh.MarshalPrivateKey(priv, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := cryptossh.NewSignerFromKey(priv)
+	key = signer.PublicKey()
+	c.keys["key-test"] = map[string]interface{}{"id": "key-test", "key": string(cryptossh.MarshalAuthorizedKey(key))}
+	for _, inst := range c.instances {
+		inst["public_keys"] = []interface{}{"key-test"}
+	}
+	return
+}
+
+func TestDial(t *testing.T) {
+	c := newFakeClient()
+	c.instances["local"] = map[string]interface{}{"id": "local", "network_adapters": []interface{}{adapter(map[string]interface{}{"address": "127.0.0.1", "type": "public"})}}
+	identity, key := testIdentity(t, c)
+	server := newTestServer(t, key)
+	_, port, _ := net.SplitHostPort(server.addr)
+	opts := Options{IdentityFiles: []string{identity}, HostKeyCallback: cryptossh.FixedHostKey(server.hostKey)}
+	opts.Port, _ = strconv.Atoi(port)
+
+	session, client, err := NewSession(c, "local", opts)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	out, err := session.Output("true")
+	client.Close()
+	if err != nil || string(out) != "hello" {
+		t.Errorf("Expected the session to work, got %q %v", out, err)
+	}
+
+	// The same server stands in for the private instance behind the bastion
+	c.instances["local"]["id"] = "bastion"
+	c.instances["bastion"] = c.instances["local"]
+	opts.BastionId, opts.BastionUser = "bastion", "jump"
+	client, err = Dial(c, "db", opts)
+	if err != nil {
+		t.Fatalf("Dial through the bastion failed: %v", err)
+	}
+	client.Close()
+	server.mu.Lock()
+	logins, forward := server.logins, server.forward
+	server.mu.Unlock()
+	if want := []string{"root", "jump", "root"}; !reflect.DeepEqual(logins, want) {
+		t.Errorf("Expected logins %v, got %v", want, logins)
+	}
+	if want := []string{"10.0.0.20:" + port}; !reflect.DeepEqual(forward, want) {
+		t.Errorf("Expected the bastion to forward to %v, got %v", want, forward)
+	}
+
+	opts.BastionId = ""
+	opts.HostKeyCallback = cryptossh.FixedHostKey(key) //not the server's
+	if _, err := Dial(c, "local", opts); err == nil {
+		t.Errorf("Expected a mismatched host key to be refused")
+	}
+	opts.HostKeyCallback = nil
+	if _, err := Dial(c, "local", opts); err == nil || !strings.Contains(err.Error(), "HostKeyCallback") {
+		t.Errorf("Expected a missing HostKeyCallback to be refused, got %v", err)
+	}
+}
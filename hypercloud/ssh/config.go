@@ -0,0 +1,90 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/TheHyperCloud/hypercloud-go-client/hypercloud"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// An ssh_config(5) Host block for the instance, e.g.
+//
+//	Host web-1
+//	    HostName 203.0.113.10
+//	    User root
+//	    IdentityFile ~/.ssh/id_ed25519
+//	    IdentitiesOnly yes
+//
+// Instances behind a bastion get a ProxyJump line. Only identity files whose
+// public half (the .pub next to it, or the key itself if unencrypted) is
+// attached to the instance are listed.
+func ConfigStanza(c Client, instanceId string, alias string, opts Options) (stanza string, err []error) {
+	target, err := resolve(c, instanceId, opts)
+	if err != nil {
+		return
+	}
+	files, err := matchingIdentityFiles(c, instanceId, opts.IdentityFiles)
+	if err != nil {
+		return
+	}
+	if alias == "" {
+		alias = instanceId
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Host %s\n", alias)
+	fmt.Fprintf(&b, "    HostName %s\n", target.Host)
+	if target.Port != 22 {
+		fmt.Fprintf(&b, "    Port %d\n", target.Port)
+	}
+	fmt.Fprintf(&b, "    User %s\n", opts.user())
+	for _, f := range files {
+		fmt.Fprintf(&b, "    IdentityFile %s\n", f)
+	}
+	if len(files) > 0 {
+		b.WriteString("    IdentitiesOnly yes\n")
+	}
+	if target.Bastion != nil {
+		fmt.Fprintf(&b, "    ProxyJump %s@%s\n", opts.bastionUser(), target.Bastion.Addr())
+	}
+	stanza = b.String()
+	return
+}
+
+func matchingIdentityFiles(c Client, instanceId string, files []string) (matched []string, err []error) {
+	attached, err := AttachedKeys(c, instanceId)
+	if err != nil {
+		return
+	}
+	for _, path := range files {
+		blob, erro := identityPublicBlob(path)
+		if erro != nil {
+			err = append(err, erro)
+			continue
+		}
+		for _, key := range attached {
+			if string(key.Blob) == string(blob) {
+				matched = append(matched, path)
+				break
+			}
+		}
+	}
+	return
+}
+
+func identityPublicBlob(path string) ([]byte, error) {
+	if keys, errs := hypercloud.ReadSSHPublicKeyFile(path + ".pub"); errs == nil && len(keys) > 0 {
+		return keys[0].Blob, nil
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := cryptossh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v (and no readable %s.pub)", path, err, path)
+	}
+	return signer.PublicKey().Marshal(), nil
+}
@@ -0,0 +1,67 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	aliceKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ3L283MYEhTKOCLaiVpbuaXHf2FALF+zWXE8Q2H7RG3 alice@laptop"
+	bobKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDopnL5/TgiJD6NBVPolDke0SGNjyU9ailB36y8xzIYh bob"
+)
+
+// Writes the public halves, ConfigStanza doesn't need the private keys when they're there
+func identityFiles(t *testing.T, keys map[string]string) map[string]string {
+	dir := t.TempDir()
+	paths := make(map[string]string)
+	for name, key := range keys {
+		paths[name] = filepath.Join(dir, name)
+		if err := os.WriteFile(paths[name]+".pub", []byte(key+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestConfigStanza(t *testing.T) {
+	c := newFakeClient()
+	c.keys["key-alice"] = map[string]interface{}{"id": "key-alice", "key": aliceKey}
+	c.instances["web"]["public_keys"] = []interface{}{"key-alice"}
+	c.instances["db"]["public_keys"] = []interface{}{map[string]interface{}{"id": "key-bob", "key": bobKey}}
+	files := identityFiles(t, map[string]string{"id_alice": aliceKey, "id_bob": bobKey})
+	opts := Options{IdentityFiles: []string{files["id_alice"], files["id_bob"]}}
+
+	stanza, err := ConfigStanza(c, "web", "web-1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Host web-1\n" +
+		"    HostName 203.0.113.10\n" +
+		"    User root\n" +
+		"    IdentityFile " + files["id_alice"] + "\n" +
+		"    IdentitiesOnly yes\n"
+	if stanza != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, stanza)
+	}
+
+	opts.User, opts.BastionUser, opts.BastionId = "ubuntu", "jump", "bastion"
+	stanza, err = ConfigStanza(c, "db", "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "Host db\n" +
+		"    HostName 10.0.0.20\n" +
+		"    User ubuntu\n" +
+		"    IdentityFile " + files["id_bob"] + "\n" +
+		"    IdentitiesOnly yes\n" +
+		"    ProxyJump jump@198.51.100.1:22\n"
+	if stanza != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, stanza)
+	}
+
+	opts.IdentityFiles = []string{filepath.Join(t.TempDir(), "missing")}
+	if _, err := ConfigStanza(c, "db", "", opts); err == nil {
+		t.Errorf("Expected an unreadable identity file to be reported")
+	}
+}
@@ -0,0 +1,282 @@
+package hypercloud
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Picking a template by what it is rather than by id, e.g. "the newest ubuntu
+// LTS in SY3", with every step of the decision written down so a manifest
+// always resolves the same way and you can see why.
+
+type TemplateQuery struct {
+	Family  string //os family, e.g. "ubuntu", "centos"
+	Version string //exact ("16.04") or prefix ("16") match, empty for any
+	LTS     bool   //only long term support releases
+	Slug    string //glob matched against the slug, e.g. "ubuntu-16-*"
+	Region  string //region id or code, e.g. "SY3"
+
+	// Superseded templates are left out unless this is set, a template only
+	// counts as superseded where its successor is available
+	IncludeSuperseded bool
+}
+
+// The template picked and how we got there
+type TemplateResolution struct {
+	Template interface{}
+	Id       string
+	Steps    []string
+}
+
+func (r *TemplateResolution) logf(format string, args ...interface{}) {
+	r.Steps = append(r.Steps, fmt.Sprintf(format, args...))
+}
+
+// Where the API doesn't give a family/version we fall back on the slug, e.g. ubuntu-16-04
+func templateFamily(t interface{}) string {
+	for _, key := range []string{"family", "os_family", "distribution", "os"} {
+		if f := stringOf(t, key); f != "" {
+			return strings.ToLower(f)
+		}
+	}
+	slug := stringOf(t, "slug")
+	if i := strings.IndexAny(slug, "-_"); i > 0 {
+		return strings.ToLower(slug[:i])
+	}
+	return strings.ToLower(slug)
+}
+
+func templateVersion(t interface{}) string {
+	if v := stringOf(t, "version"); v != "" {
+		return v
+	}
+	var parts []string
+	for _, p := range strings.FieldsFunc(stringOf(t, "slug"), func(r rune) bool { return r == '-' || r == '_' }) {
+		if _, err := strconv.Atoi(p); err == nil {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func templateLTS(t interface{}) bool {
+	if m := mapOf(t); m != nil {
+		if lts, ok := m["lts"].(bool); ok {
+			return lts
+		}
+	}
+	words := strings.FieldsFunc(strings.ToLower(stringOf(t, "name")+" "+stringOf(t, "slug")), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	for _, w := range words {
+		if w == "lts" {
+			return true
+		}
+	}
+	// Ubuntu LTS releases are the April releases of even years
+	if templateFamily(t) == "ubuntu" {
+		v := strings.Split(templateVersion(t), ".")
+		if len(v) >= 2 {
+			year, err := strconv.Atoi(v[0])
+			return err == nil && year%2 == 0 && v[1] == "04"
+		}
+	}
+	return false
+}
+
+func templateSupersededBy(t interface{}) string {
+	for _, key := range []string{"superseded_by", "successor"} {
+		if s := idOf(mapOf(t)[key]); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func templateInRegion(t interface{}, region string) bool {
+	r := mapOf(t)["region"]
+	return idOf(r) == region || strings.EqualFold(stringOf(r, "code"), region)
+}
+
+// Compares dotted versions numerically, 16.10 > 16.04 > 14.04
+func compareVersions(a string, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (q TemplateQuery) matches(t interface{}) bool {
+	if q.Family != "" && templateFamily(t) != strings.ToLower(q.Family) {
+		return false
+	}
+	if q.Version != "" {
+		v := templateVersion(t)
+		if v != q.Version && !strings.HasPrefix(v, q.Version+".") {
+			return false
+		}
+	}
+	if q.LTS && !templateLTS(t) {
+		return false
+	}
+	if q.Slug != "" {
+		if ok, _ := path.Match(q.Slug, stringOf(t, "slug")); !ok {
+			return false
+		}
+	}
+	if q.Region != "" && !templateInRegion(t, q.Region) {
+		return false
+	}
+	return true
+}
+
+// Whether a template has been replaced by one usable in the region, going by
+// the supersede chain through the templates listed. A successor elsewhere (or
+// one we can't see) doesn't make the template any less current here.
+func templateSupersededIn(t interface{}, byId map[string]interface{}, region string) bool {
+	if region == "" {
+		return templateSupersededBy(t) != ""
+	}
+	seen := map[string]bool{idOf(t): true}
+	for next := templateSupersededBy(t); next != "" && !seen[next]; next = templateSupersededBy(byId[next]) {
+		seen[next] = true
+		successor, ok := byId[next]
+		if !ok {
+			return false
+		}
+		if templateInRegion(successor, region) {
+			return true
+		}
+	}
+	return false
+}
+
+// All templates matching the query, newest version first (ties by slug then id,
+// so the order never depends on the API's)
+func (h *hypercloud) TemplateSearch(q TemplateQuery) (templates []interface{}, err []error) {
+	list, err := h.TemplateList()
+	if err != nil {
+		return
+	}
+	byId := make(map[string]interface{})
+	for _, t := range sliceOf(list) {
+		byId[idOf(t)] = t
+	}
+	for _, t := range sliceOf(list) {
+		if q.matches(t) && (q.IncludeSuperseded || !templateSupersededIn(t, byId, q.Region)) {
+			templates = append(templates, t)
+		}
+	}
+	sort.SliceStable(templates, func(i, j int) bool {
+		if c := compareVersions(templateVersion(templates[i]), templateVersion(templates[j])); c != 0 {
+			return c > 0
+		}
+		if si, sj := stringOf(templates[i], "slug"), stringOf(templates[j], "slug"); si != sj {
+			return si < sj
+		}
+		return idOf(templates[i]) < idOf(templates[j])
+	})
+	return
+}
+
+// The newest template matching the query, with any supersede chain followed to its end
+func (h *hypercloud) TemplateLatest(q TemplateQuery) (res TemplateResolution, err []error) {
+	res.logf("query %+v", q)
+	templates, err := h.TemplateSearch(q)
+	if err != nil {
+		return
+	}
+	if len(templates) == 0 {
+		err = append(err, fmt.Errorf("No template matches %+v", q))
+		return
+	}
+	for _, t := range templates {
+		res.logf("candidate %s (%s, version %s)", idOf(t), stringOf(t, "slug"), templateVersion(t))
+	}
+	res.logf("picked %s", idOf(templates[0]))
+	followed, err := h.templateFollow(idOf(templates[0]), q.Region)
+	res.Steps = append(res.Steps, followed.Steps...)
+	res.Template, res.Id = followed.Template, followed.Id
+	return
+}
+
+// Follows superseded_by links from a template to the one that replaced it last
+func (h *hypercloud) TemplateFollow(templateId string) (res TemplateResolution, err []error) {
+	return h.templateFollow(templateId, "")
+}
+
+// With a region the chain ends on the last template in it, a successor
+// elsewhere can't be used there
+func (h *hypercloud) templateFollow(templateId string, region string) (res TemplateResolution, err []error) {
+	seen := make(map[string]bool)
+	var last interface{} //the last template usable in the region
+	for {
+		if seen[templateId] {
+			err = append(err, fmt.Errorf("Template supersede chain loops at %s", templateId))
+			return
+		}
+		seen[templateId] = true
+		t, erro := h.TemplateInfo(templateId)
+		if erro != nil {
+			err = append(err, erro...)
+			return
+		}
+		if last == nil || region == "" || templateInRegion(t, region) {
+			last = t
+		} else {
+			res.logf("%s is not in region %s, skipping it", templateId, region)
+		}
+		next := templateSupersededBy(t)
+		if next == "" {
+			break
+		}
+		res.logf("%s is superseded by %s", templateId, next)
+		templateId = next
+	}
+	res.Template, res.Id = last, idOf(last)
+	if res.Id != templateId {
+		res.logf("staying on %s, the last of the chain in region %s", res.Id, region)
+	}
+	res.logf("resolved to %s (%s)", res.Id, stringOf(last, "slug"))
+	return
+}
+
+// Parses a manifest style spec such as "ubuntu lts latest", "ubuntu 16.04" or
+// "centos 7 latest" and resolves it in the region.
+func (h *hypercloud) TemplateResolve(spec string, region string) (res TemplateResolution, err []error) {
+	q := TemplateQuery{Region: region}
+	for i, word := range strings.Fields(strings.ToLower(spec)) {
+		switch {
+		case i == 0:
+			q.Family = word
+		case word == "lts":
+			q.LTS = true
+		case word == "latest":
+		case strings.ContainsAny(word, "*?["):
+			q.Slug = word
+		default:
+			q.Version = word
+		}
+	}
+	if q.Family == "" {
+		err = append(err, fmt.Errorf("Empty template spec"))
+		return
+	}
+	return h.TemplateLatest(q)
+}
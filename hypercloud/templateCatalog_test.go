@@ -0,0 +1,108 @@
+package hypercloud
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateHeuristics(t *testing.T) {
+	cases := []struct {
+		template map[string]interface{}
+		family   string
+		version  string
+		lts      bool
+	}{
+		{map[string]interface{}{"slug": "ubuntu-16-04"}, "ubuntu", "16.04", true},
+		{map[string]interface{}{"slug": "ubuntu-16-10"}, "ubuntu", "16.10", false},
+		{map[string]interface{}{"slug": "ubuntu-15-04"}, "ubuntu", "15.04", false},
+		{map[string]interface{}{"slug": "centos_7", "name": "CentOS 7 LTS"}, "centos", "7", true},
+		{map[string]interface{}{"slug": "debian-9", "name": "Debian 9 (defaults)"}, "debian", "9", false},
+		{map[string]interface{}{"slug": "ubuntu-18-04", "lts": false}, "ubuntu", "18.04", false},
+		{map[string]interface{}{"slug": "custom", "family": "Ubuntu", "version": "14.04"}, "ubuntu", "14.04", true},
+	}
+	for _, c := range cases {
+		if f := templateFamily(c.template); f != c.family {
+			t.Errorf("%v: expected family %s, got %s", c.template, c.family, f)
+		}
+		if v := templateVersion(c.template); v != c.version {
+			t.Errorf("%v: expected version %s, got %s", c.template, c.version, v)
+		}
+		if lts := templateLTS(c.template); lts != c.lts {
+			t.Errorf("%v: expected lts %v", c.template, c.lts)
+		}
+	}
+}
+
+func TestTemplateResolve(t *testing.T) {
+	sy3 := map[string]interface{}{"id": "region-1", "code": "SY3"}
+	me1 := map[string]interface{}{"id": "region-2", "code": "ME1"}
+	cloud := newFakeCloud()
+	cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-14-04", "region": sy3})
+	b := cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-16-04", "region": sy3})
+	// Same version and slug, the lower id wins whatever order the API lists them in
+	a := cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-16-04", "region": sy3})
+	cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-16-10", "region": sy3})
+	cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-18-04", "region": me1})
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	for i := 0; i < 5; i++ {
+		res, err := hc.TemplateResolve("ubuntu lts latest", "SY3")
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if want := min(a, b); res.Id != want {
+			t.Fatalf("Expected %s, got %s (%v)", want, res.Id, res.Steps)
+		}
+	}
+	if res, _ := hc.TemplateResolve("ubuntu latest", "SY3"); stringOf(res.Template, "slug") != "ubuntu-16-10" {
+		t.Errorf("Expected the newest version without lts, got %v", res.Template)
+	}
+	if res, _ := hc.TemplateResolve("ubuntu 14", "SY3"); stringOf(res.Template, "slug") != "ubuntu-14-04" {
+		t.Errorf("Expected a version prefix match, got %v", res.Template)
+	}
+}
+
+func TestTemplateFollowStaysInRegion(t *testing.T) {
+	sy3 := map[string]interface{}{"id": "region-1", "code": "SY3"}
+	me1 := map[string]interface{}{"id": "region-2", "code": "ME1"}
+	cloud := newFakeCloud()
+	elsewhere := cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-16-04", "region": me1})
+	old := cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-16-04", "region": sy3, "superseded_by": elsewhere})
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	res, err := hc.TemplateResolve("ubuntu latest", "SY3")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if res.Id != old {
+		t.Errorf("Expected to stay on %s in SY3, got %s", old, res.Id)
+	}
+	if !strings.Contains(strings.Join(res.Steps, "\n"), "not in region SY3") {
+		t.Errorf("Expected the region stop to be logged: %v", res.Steps)
+	}
+	if res, _ := hc.TemplateFollow(old); res.Id != elsewhere {
+		t.Errorf("Expected TemplateFollow without a region to follow the whole chain, got %s", res.Id)
+	}
+}
+
+func TestTemplateFollowSkipsOtherRegions(t *testing.T) {
+	sy3 := map[string]interface{}{"id": "region-1", "code": "SY3"}
+	me1 := map[string]interface{}{"id": "region-2", "code": "ME1"}
+	cloud := newFakeCloud()
+	newest := cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-16-04", "region": sy3})
+	between := cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-16-04", "region": me1, "superseded_by": newest})
+	old := cloud.addTemplate(map[string]interface{}{"slug": "ubuntu-16-04", "region": sy3, "superseded_by": between})
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	if res, _ := hc.TemplateResolve("ubuntu latest", "SY3"); res.Id != newest {
+		t.Errorf("Expected %s through the chain, got %s (%v)", newest, res.Id, res.Steps)
+	}
+	res, _ := hc.templateFollow(old, "SY3")
+	if res.Id != newest || !strings.Contains(strings.Join(res.Steps, "\n"), between+" is not in region SY3") {
+		t.Errorf("Expected to follow past %s to %s, got %s (%v)", between, newest, res.Id, res.Steps)
+	}
+	// Its successor isn't in ME1, so it's still the current one there
+	if res, _ := hc.TemplateResolve("ubuntu latest", "ME1"); res.Id != between {
+		t.Errorf("Expected %s in ME1, got %s (%v)", between, res.Id, res.Steps)
+	}
+}
@@ -0,0 +1,101 @@
+package hypercloud
+
+import (
+	"fmt"
+	"time"
+)
+
+// Golden images: publishing a prepared disk as a template of our own.
+
+type TemplatePublishOptions struct {
+	Name       string
+	Slug       string
+	Supersedes string //id of an older template this one replaces
+	Timeout    time.Duration
+
+	// Delete the source disk once the template is available
+	DeleteSource bool
+}
+
+var templateReadyStates = map[string]bool{"available": true, "ready": true, "active": true}
+var templateFailedStates = map[string]bool{"failed": true, "error": true}
+
+// Publishes a detached disk as a template (superseding an older one if asked)
+// and waits until the template is usable in the disk's region, i.e. reports a
+// ready state and that region. Only then is the source deleted, if asked.
+func (h *hypercloud) TemplatePublish(diskId string, opts TemplatePublishOptions) (template interface{}, err []error) {
+	disk, err := h.DiskInfo(diskId)
+	if err != nil {
+		return
+	}
+	if state := stringOf(disk, "state"); state != DiskStateUnattached {
+		err = append(err, fmt.Errorf("Disk %s must be detached before it is published, it is %s", diskId, state))
+		return
+	}
+	if opts.Name == "" {
+		err = append(err, fmt.Errorf("A template needs a name"))
+		return
+	}
+	if opts.Supersedes != "" {
+		if _, erro := h.TemplateInfo(opts.Supersedes); erro != nil {
+			err = append(err, fmt.Errorf("Template %s to supersede: %v", opts.Supersedes, erro))
+			return
+		}
+	}
+
+	body := map[string]interface{}{"name": opts.Name, "disk": diskId}
+	if opts.Slug != "" {
+		body["slug"] = opts.Slug
+	}
+	if opts.Supersedes != "" {
+		body["supersedes"] = opts.Supersedes
+	}
+	template, err = h.TemplateSupersede(body)
+	if err != nil {
+		return
+	}
+	templateId := idOf(template)
+	if templateId == "" {
+		err = append(err, fmt.Errorf("Publishing disk %s returned no template id", diskId))
+		return
+	}
+
+	region := idOf(mapOf(disk)["region"])
+	if template, err = h.templateWaitAvailable(templateId, region, opts.Timeout); err != nil {
+		return
+	}
+	if opts.DeleteSource {
+		_, err = h.DiskDelete(diskId)
+	}
+	return
+}
+
+func (h *hypercloud) templateWaitAvailable(templateId string, region string, timeout time.Duration) (template interface{}, err []error) {
+	h, done := h.workflow("TemplateWaitAvailable", map[string]string{"template_id": templateId, "region": region})
+	defer func() { done(err) }()
+	if timeout == 0 {
+		timeout = 30 * time.Minute
+	}
+	end := time.Now().Add(timeout)
+	for {
+		template, err = h.TemplateInfo(templateId)
+		if err != nil {
+			return
+		}
+		state := stringOf(template, "state")
+		if templateFailedStates[state] {
+			err = append(err, fmt.Errorf("Template %s failed to publish (%s)", templateId, state))
+			return
+		}
+		// Only a state we know to be ready counts, and the template has to say
+		// it's in the region, the source may be deleted once we return
+		if templateReadyStates[state] && (region == "" || templateInRegion(template, region)) {
+			return
+		}
+		if !end.After(time.Now()) {
+			err = append(err, fmt.Errorf("Timed out waiting for template %s to become available (currently %s)", templateId, state))
+			return
+		}
+		time.Sleep(pollInterval)
+	}
+}
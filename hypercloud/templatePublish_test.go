@@ -0,0 +1,87 @@
+package hypercloud
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplatePublish(t *testing.T) {
+	pollInterval = time.Millisecond
+	cloud := newFakeCloud()
+	golden := cloud.addDisk("golden")
+	cloud.disks[golden]["region"] = "region-1"
+	old := cloud.addTemplate(map[string]interface{}{"slug": "golden-1", "region": "region-1"})
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	template, err := hc.TemplatePublish(golden, TemplatePublishOptions{Name: "golden", Slug: "golden-2", Supersedes: old, Timeout: time.Second, DeleteSource: true})
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	id := idOf(template)
+	if stringOf(template, "state") != "available" || idOf(mapOf(template)["region"]) != "region-1" {
+		t.Errorf("Expected to wait for the template to be available in the disk's region, got %v", template)
+	}
+	if polls := strings.Count(strings.Join(cloud.calls, "\n"), "GET /templates/"+id); polls != pendingPolls+1 {
+		t.Errorf("Expected %d polls of the new template, got %d", pendingPolls+1, polls)
+	}
+	if cloud.templates[old]["superseded_by"] != id {
+		t.Errorf("Expected %s to be superseded by %s, got %v", old, id, cloud.templates[old])
+	}
+	if _, ok := cloud.disks[golden]; ok {
+		t.Errorf("Expected the source disk to be deleted")
+	}
+}
+
+func TestTemplatePublishRefuses(t *testing.T) {
+	cloud := newFakeCloud()
+	attached := cloud.addDisk("attached")
+	cloud.disks[attached]["state"] = DiskStateAttached
+	detached := cloud.addDisk("detached")
+	hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+	tests := map[string]struct {
+		disk string
+		opts TemplatePublishOptions
+		want string
+	}{
+		"attached disk":      {attached, TemplatePublishOptions{Name: "golden"}, "must be detached"},
+		"no name":            {detached, TemplatePublishOptions{}, "needs a name"},
+		"unknown supersedes": {detached, TemplatePublishOptions{Name: "golden", Supersedes: "template-nope"}, "template-nope to supersede"},
+	}
+	for name, tt := range tests {
+		if _, err := hc.TemplatePublish(tt.disk, tt.opts); len(err) != 1 || !strings.Contains(err[0].Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", name, tt.want, err)
+		}
+	}
+	if len(cloud.templates) != 0 {
+		t.Errorf("Expected nothing to be published, got %v", cloud.templates)
+	}
+	if _, ok := cloud.disks[detached]; !ok {
+		t.Errorf("Expected the source disk to be kept")
+	}
+}
+
+func TestTemplatePublishWaitsUntilUsable(t *testing.T) {
+	pollInterval = time.Millisecond
+	cases := map[string]map[string]interface{}{
+		"no state":  {"state": ""},
+		"no region": {"state": "available", "region": nil},
+		"elsewhere": {"state": "available", "region": "region-2"},
+	}
+	for name, fields := range cases {
+		cloud := newFakeCloud()
+		golden := cloud.addDisk("golden")
+		cloud.disks[golden]["region"] = "region-1"
+		cloud.published = fields
+		hc := newTestHypercloud(t, cloud.ServeHTTP)
+
+		_, err := hc.TemplatePublish(golden, TemplatePublishOptions{Name: "golden", Timeout: 20 * time.Millisecond, DeleteSource: true})
+		if err == nil || !strings.Contains(err[0].Error(), "Timed out") {
+			t.Errorf("%s: expected to time out waiting for the template, got %v", name, err)
+		}
+		if _, ok := cloud.disks[golden]; !ok {
+			t.Errorf("%s: source disk deleted before the template was usable", name)
+		}
+	}
+}